@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// emptyLike returns a new, empty State with the same settings as state but no WAL.
+func (state *State[T]) emptyLike() *State[T] {
+	empty := NewShardedState[T](state.cleanupFreq, state.maxChildren, state.nodes.shardCount())
+	empty.CleanupPolicy = state.CleanupPolicy
+	empty.maxNodes = state.maxNodes
+	empty.nameValidator.Store(state.nameValidator.Load())
+	return empty
+}
+
+// copyNodes creates each node in dst with its current text and TTL deadline, then wires every
+// live edge (with its labels) whose endpoints were both copied. The copies share no pointers with the source.
+// Names skip dst's validator, since they were already stored once. It returns one error per node
+// or edge that could not be copied, joined.
+func copyNodes[T any](dst *State[T], nodes []*Node[T]) error {
+	var errs []error
+	for _, node := range nodes {
+		if err := dst.createTrusted(node.name, node.getText(), node.expires); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", node.name, err))
+		}
+	}
+	for _, node := range nodes {
+		for _, child := range node.getValidChildren() {
+			if _, exists := dst.get(child.name); !exists {
+				continue
+			}
+			for _, label := range node.edgeLabels(child.name) {
+				if err := dst.connect(node.name, child.name, label); err != nil {
+					errs = append(errs, fmt.Errorf("%s -> %s: %w", node.name, child.name, err))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// subgraph returns a new State holding the nodes reachable from roots and the edges among them.
+func (state *State[T]) subgraph(roots []string) (*State[T], error) {
+	seen := make(map[string]bool)
+	var nodes []*Node[T]
+	for _, root := range roots {
+		if _, exists := state.get(root); !exists {
+			return nil, ErrNodeNotFound
+		}
+		if seen[root] {
+			continue
+		}
+		reachable, err := state.bfs(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range reachable {
+			if !seen[node.name] {
+				seen[node.name] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	sub := state.emptyLike()
+	if err := copyNodes(sub, nodes); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Clone returns an independent copy of every live node and edge. Edges are rebuilt by name
+// with fresh pointers, so mutating one State never affects the other. Payloads are copied
+// by assignment, so a T that holds references still shares what it points to.
+// The error joins every node or edge that could not be copied.
+func (state *State[T]) Clone() (*State[T], error) {
+	var nodes []*Node[T]
+	state.Range(func(_ string, node *Node[T]) bool {
+		if !node.dead.Load() {
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+	clone := state.emptyLike()
+	if err := copyNodes(clone, nodes); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// Merge imports every live node and edge from other. When a name exists in both,
+// onConflict picks the text to keep; a nil onConflict keeps this state's text.
+// Edges from both sides are unioned, labels included. Nodes that cannot be created for any
+// other reason, such as the node limit or the name validator, are reported in the error.
+func (state *State[T]) Merge(other *State[T], onConflict func(name string, mine, theirs *Node[T]) T) error {
+	var theirs []*Node[T]
+	other.Range(func(_ string, node *Node[T]) bool {
+		if !node.dead.Load() {
+			theirs = append(theirs, node)
+		}
+		return true
+	})
+	var errs []error
+	for _, node := range theirs {
+		err := state.create(node.name, node.getText())
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrNodeExists) {
+			errs = append(errs, fmt.Errorf("%s: %w", node.name, err))
+			continue
+		}
+		mine, exists := state.get(node.name)
+		if !exists || onConflict == nil {
+			continue
+		}
+		if err := state.update(node.name, onConflict(node.name, mine, node)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, node := range theirs {
+		for _, child := range node.getValidChildren() {
+			for _, label := range node.edgeLabels(child.name) {
+				if err := state.connect(node.name, child.name, label); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Diff compares the live nodes of a and b by name and text. added are in b but not a,
+// removed are in a but not b, and changed are in both with texts that differ under
+// reflect.DeepEqual. Each slice is sorted. Edges are not compared.
+func Diff[T any](a, b *State[T]) (added, removed, changed []string) {
+	texts := make(map[string]T)
+	a.Range(func(name string, node *Node[T]) bool {
+		if !node.dead.Load() {
+			texts[name] = node.getText()
+		}
+		return true
+	})
+	b.Range(func(name string, node *Node[T]) bool {
+		if node.dead.Load() {
+			return true
+		}
+		old, exists := texts[name]
+		if !exists {
+			added = append(added, name)
+		} else if !reflect.DeepEqual(old, node.getText()) {
+			changed = append(changed, name)
+		}
+		delete(texts, name)
+		return true
+	})
+	for name := range texts {
+		removed = append(removed, name)
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(changed)
+	return added, removed, changed
+}
+
+// duplicate creates newName with src's text and an edge, with the same labels, to each of
+// src's live children. Parents are not copied, so the duplicate starts out as a root.
+// If an edge cannot be copied, newName is removed again and the connect error returned.
+func (state *State[T]) duplicate(src, newName string) error {
+	srcNode, exists := state.get(src)
+	if !exists {
+		return ErrNodeNotFound
+	}
+	if err := state.create(newName, srcNode.getText()); err != nil {
+		return err
+	}
+	for _, child := range srcNode.getValidChildren() {
+		for _, label := range srcNode.edgeLabels(child.name) {
+			if err := state.connect(newName, child.name, label); err != nil {
+				state.remove(newName)
+				return fmt.Errorf("%s -> %s: %w", newName, child.name, err)
+			}
+		}
+	}
+	return nil
+}
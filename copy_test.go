@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSubgraph(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "a", "B": "b", "C": "c", "D": "d", "E": "e"})
+	st.connect("A", "B", "")
+	st.connect("B", "C", "x")
+	st.connect("D", "B", "")
+	st.connect("C", "E", "")
+	st.connect("D", "E", "")
+	sub, err := st.subgraph([]string{"B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"name":"B","text":"b","children":["C"],"labels":{"C":["x"]}},{"name":"C","text":"c","children":["E"]},{"name":"E","text":"e","children":[]}]`
+	if got, _ := sub.MarshalJSON(); string(got) != want {
+		t.Fatalf("subgraph(B) = %s, want %s", got, want)
+	}
+	if _, err := st.subgraph([]string{"Q"}); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("subgraph of a missing root = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "a", "B": "b"})
+	st.connect("A", "B", "")
+	before, _ := st.MarshalJSON()
+	clone, err := st.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := clone.MarshalJSON(); string(got) != string(before) {
+		t.Fatalf("clone = %s, want %s", got, before)
+	}
+	clone.update("A", "changed")
+	clone.disconnect("A", "B")
+	clone.create("C", "")
+	clone.remove("B")
+	if after, _ := st.MarshalJSON(); string(after) != string(before) {
+		t.Fatalf("mutating the clone changed the original: %s", after)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	mine := &State[string]{}
+	mine.createMany(map[string]string{"A": "mine", "B": "b"})
+	mine.connect("A", "B", "")
+	theirs := &State[string]{}
+	theirs.createMany(map[string]string{"A": "theirs", "C": "c"})
+	theirs.connect("A", "C", "")
+	err := mine.Merge(theirs, func(_ string, m, th *Node[string]) string {
+		return m.getText() + "+" + th.getText()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"name":"A","text":"mine+theirs","children":["B","C"]},{"name":"B","text":"b","children":[]},{"name":"C","text":"c","children":[]}]`
+	if got, _ := mine.MarshalJSON(); string(got) != want {
+		t.Fatalf("merged = %s, want %s", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := &State[string]{}
+	a.createMany(map[string]string{"same": "s", "gone": "", "chg": "1"})
+	b := &State[string]{}
+	b.createMany(map[string]string{"same": "s", "new": "", "chg": "2"})
+	added, removed, changed := Diff(a, b)
+	if !slices.Equal(added, []string{"new"}) || !slices.Equal(removed, []string{"gone"}) || !slices.Equal(changed, []string{"chg"}) {
+		t.Fatalf("Diff = %v, %v, %v; want [new], [gone], [chg]", added, removed, changed)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"s": "tmpl", "a": "", "b": "", "p": ""})
+	st.connect("s", "a", "x")
+	st.connect("s", "b", "")
+	st.connect("p", "s", "")
+	if err := st.duplicate("s", "d"); err != nil {
+		t.Fatal(err)
+	}
+	sc, _ := st.childrenOf("s")
+	dc, _ := st.childrenOf("d")
+	s, _ := st.get("s")
+	d, _ := st.get("d")
+	if !slices.Equal(sc, dc) || d == s || d.getText() != "tmpl" {
+		t.Fatalf("duplicate has children %v and text %q, want %v and tmpl", dc, d.getText(), sc)
+	}
+	if !slices.Equal(d.edgeLabels("a"), []string{"x"}) || len(d.getParents()) != 0 {
+		t.Fatal("duplicate must copy edge labels but not parents")
+	}
+	st.update("d", "changed")
+	if s.getText() != "tmpl" {
+		t.Fatal("updating the duplicate changed the source")
+	}
+	if err := st.duplicate("s", "a"); !errors.Is(err, ErrNodeExists) {
+		t.Fatalf("duplicate onto a taken name = %v, want ErrNodeExists", err)
+	}
+	if err := st.duplicate("zz", "q"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("duplicate of a missing node = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestMergeReportsNodesItCannotCreate(t *testing.T) {
+	mine := NewStateWithLimit[string](2)
+	mine.create("A", "")
+	mine.SetNameValidator(func(name string) error {
+		if strings.Contains(name, " ") {
+			return errors.New("no spaces")
+		}
+		return nil
+	})
+	theirs := &State[string]{}
+	theirs.createMany(map[string]string{"A": "", "B": "", "C": "", "bad name": ""})
+	err := mine.Merge(theirs, nil)
+	if !errors.Is(err, ErrNodeLimit) || !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("Merge = %v, want ErrNodeLimit and ErrInvalidName", err)
+	}
+	if n := mine.Count(); n != 2 {
+		t.Fatalf("merged store holds %d nodes, want its limit of 2", n)
+	}
+}
+
+func TestCopiesKeepNamesStoredBeforeValidator(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a b": "", "c": ""})
+	st.connect("a b", "c", "")
+	st.SetNameValidator(func(name string) error {
+		if strings.Contains(name, " ") {
+			return errors.New("no spaces")
+		}
+		return nil
+	})
+	clone, err := st.Clone()
+	if err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	if children, _ := clone.childrenOf("a b"); !slices.Equal(children, []string{"c"}) {
+		t.Fatalf("clone children of %q = %v, want [c]", "a b", children)
+	}
+	sub, err := st.subgraph([]string{"a b"})
+	if err != nil {
+		t.Fatalf("subgraph: %v", err)
+	}
+	if _, exists := sub.get("a b"); !exists {
+		t.Fatalf("subgraph dropped %q", "a b")
+	}
+	h := st.WithHistory()
+	if err := h.remove("a b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Undo(); err != nil {
+		t.Fatalf("undoing the remove: %v", err)
+	}
+	if children, _ := st.childrenOf("a b"); !slices.Equal(children, []string{"c"}) {
+		t.Fatalf("children of %q after Undo = %v, want [c]", "a b", children)
+	}
+}
+
+// connectRefusingWAL accepts every op except connects.
+type connectRefusingWAL[T any] struct{}
+
+func (connectRefusingWAL[T]) Append(op Op[T]) error {
+	if op.Kind == OpConnect {
+		return errors.New("disk full")
+	}
+	return nil
+}
+
+func TestDuplicateRollsBackOnConnectError(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"s": "", "a": ""})
+	st.connect("s", "a", "")
+	st.wal = connectRefusingWAL[string]{}
+	if err := st.duplicate("s", "d"); err == nil {
+		t.Fatal("duplicate succeeded although its edge could not be logged")
+	}
+	if _, exists := st.get("d"); exists {
+		t.Fatal("duplicate left a half-built d behind")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// dotEscaper escapes a string for use inside a double-quoted Graphviz ID.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote returns s as a double-quoted Graphviz ID.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// ToDOT renders the live graph as a Graphviz digraph. Nodes are labelled with their text
+// (formatted with fmt.Sprint) and edges carrying anything other than the default label
+// are labelled with their labels. Output is sorted by name so it is deterministic.
+func (state *State[T]) ToDOT() string {
+	adj := state.AdjacencyList()
+	names := make([]string, 0, len(adj))
+	for name := range adj {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteString("digraph {\n")
+	for _, name := range names {
+		node, exists := state.get(name)
+		if !exists {
+			continue
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s];\n", dotQuote(name), dotQuote(fmt.Sprint(node.getText())))
+	}
+	for _, name := range names {
+		node, exists := state.get(name)
+		if !exists {
+			continue
+		}
+		for _, child := range adj[name] {
+			fmt.Fprintf(&b, "\t%s -> %s", dotQuote(name), dotQuote(child))
+			if labels := node.edgeLabels(child); !slices.Equal(labels, []string{""}) {
+				fmt.Fprintf(&b, " [label=%s]", dotQuote(strings.Join(labels, ", ")))
+			}
+			b.WriteString(";\n")
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestToDOT(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "Parent \"x\"", "B1": "b", "B2": ""})
+	st.connect("A", "B1", "")
+	st.connect("A", "B2", "owns")
+	want := "digraph {\n" +
+		"\t\"A\" [label=\"Parent \\\"x\\\"\"];\n" +
+		"\t\"B1\" [label=\"b\"];\n" +
+		"\t\"B2\" [label=\"\"];\n" +
+		"\t\"A\" -> \"B1\";\n" +
+		"\t\"A\" -> \"B2\" [label=\"owns\"];\n" +
+		"}\n"
+	if got := st.ToDOT(); got != want {
+		t.Fatalf("ToDOT =\n%s\nwant\n%s", got, want)
+	}
+}
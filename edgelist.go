@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LoadEdgeList builds a State from CSV lines of parent,child. A leading parent,child header is skipped.
+// Nodes are created on first sight with a zero payload. Errors report the offending line number.
+func LoadEdgeList[T any](r io.Reader) (*State[T], error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	state := &State[T]{}
+	var zero T
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return state, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		parent, child := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if first && strings.EqualFold(parent, "parent") && strings.EqualFold(child, "child") {
+			continue
+		}
+		if parent == "" || child == "" {
+			return nil, fmt.Errorf("line %d: empty node name", line)
+		}
+		for _, name := range []string{parent, child} {
+			if _, exists := state.get(name); !exists {
+				state.create(name, zero)
+			}
+		}
+		if err := state.connect(parent, child, ""); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadEdgeList(t *testing.T) {
+	st, err := LoadEdgeList[string](strings.NewReader("parent,child\nA,B\nA, C\nC,D\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, e := st.Stats(); n != 4 || e != 3 {
+		t.Fatalf("Stats = %d nodes, %d edges; want 4, 3", n, e)
+	}
+	if got, _ := st.childrenOf("A"); !slices.Equal(got, []string{"B", "C"}) {
+		t.Fatalf("childrenOf(A) = %v, want [B C]", got)
+	}
+	for _, bad := range []string{"A,B\nA,A\n", "A,B\nA\n"} {
+		if _, err := LoadEdgeList[string](strings.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Fatalf("LoadEdgeList(%q) = %v, want an error for line 2", bad, err)
+		}
+	}
+}
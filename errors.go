@@ -0,0 +1,23 @@
+package main
+
+import "errors"
+
+// Errors returned by State operations. They may be wrapped with more context,
+// so compare with errors.Is rather than ==.
+var (
+	ErrNodeNotFound    = errors.New("node does not exist")
+	ErrNodeExists      = errors.New("node already exists")
+	ErrEdgeNotFound    = errors.New("edge does not exist")
+	ErrSelfLoop        = errors.New("cannot connect node to itself")
+	ErrWouldCycle      = errors.New("would create cycle")
+	ErrCycle           = errors.New("graph contains a cycle")
+	ErrChildLimit      = errors.New("node has reached its child limit")
+	ErrNodeLimit       = errors.New("store has reached its node limit")
+	ErrVersionConflict = errors.New("version conflict")
+	ErrInvalidName     = errors.New("invalid node name")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrNoPath          = errors.New("no path between nodes")
+	ErrNoTombstone     = errors.New("no tombstone for node")
+	ErrNothingToUndo   = errors.New("nothing to undo")
+	ErrNothingToRedo   = errors.New("nothing to redo")
+)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestStateErrorsAreSentinels(t *testing.T) {
+	st := NewState[string](0, 1)
+	st.create("A", "")
+	if err := st.create("A", ""); !errors.Is(err, ErrNodeExists) {
+		t.Errorf("duplicate create = %v", err)
+	}
+	if _, err := st.remove("zz"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("remove of a missing node = %v", err)
+	}
+	st.create("B", "")
+	st.create("C", "")
+	if err := st.disconnect("A", "B"); !errors.Is(err, ErrEdgeNotFound) {
+		t.Errorf("disconnect of a missing edge = %v", err)
+	}
+	if err := st.connect("A", "A", ""); !errors.Is(err, ErrSelfLoop) {
+		t.Errorf("self loop = %v", err)
+	}
+	st.connect("A", "B", "")
+	if err := st.connect("A", "C", ""); !errors.Is(err, ErrChildLimit) {
+		t.Errorf("connect over the limit = %v", err)
+	}
+	if err := st.connectMany("C", []string{"zz", "yy"}, ""); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("connectMany to missing children = %v", err)
+	}
+	if err := st.connectChecked("B", "A", ""); !errors.Is(err, ErrWouldCycle) {
+		t.Errorf("cyclic connectChecked = %v", err)
+	}
+	if _, err := st.updateIfVersion("A", "", 7); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("stale updateIfVersion = %v", err)
+	}
+	st.SetNameValidator(func(string) error { return errors.New("nope") })
+	if err := st.create("Q", ""); !errors.Is(err, ErrInvalidName) || err.Error() != "invalid node name: nope" {
+		t.Errorf("invalid name = %v", err)
+	}
+	if statusFor(ErrChildLimit) != http.StatusConflict || statusFor(errors.New("x")) != http.StatusInternalServerError {
+		t.Error("statusFor maps ErrChildLimit to 409 and unknown errors to 500")
+	}
+}
+
+func TestGraphAndHistoryErrorsAreSentinels(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "a")
+	st.create("B", "b")
+	history := st.WithHistory()
+	_, pathErr := st.shortestPath("A", "B")
+	_, depthErr := st.bfsDepth("A", -1)
+	_, pageErr := st.childrenPage("A", -1, 1)
+	for _, c := range []struct {
+		err, want error
+	}{
+		{pathErr, ErrNoPath},
+		{depthErr, ErrInvalidArgument},
+		{pageErr, ErrInvalidArgument},
+		{st.restore("A"), ErrNoTombstone},
+		{history.Undo(), ErrNothingToUndo},
+		{history.Redo(), ErrNothingToRedo},
+	} {
+		if !errors.Is(c.err, c.want) {
+			t.Errorf("got %v, want %v", c.err, c.want)
+		}
+	}
+}
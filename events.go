@@ -0,0 +1,88 @@
+package main
+
+import "sync"
+
+// eventBuffer is how many undelivered events a subscriber can hold before new ones are dropped.
+const eventBuffer = 64
+
+// Event describes a mutation that was applied to a State. Kind reuses the WAL's op kinds;
+// Name is the target node (the parent for edge events) and Other is the child or the new name.
+type Event struct {
+	Kind  OpKind
+	Name  string
+	Other string
+}
+
+// Hooks are synchronous callbacks run by the mutating goroutine after a successful op,
+// for instrumentation too light to need a subscription. A nil hook is skipped. OnConnect
+// receives the parent's name. Hooks run inline, so they must be quick and must not call back
+// into the State that invoked them.
+type Hooks struct {
+	OnCreate, OnRemove, OnConnect func(name string)
+}
+
+// run calls the hook matching event, if any.
+func (hooks *Hooks) run(event Event) {
+	var hook func(string)
+	switch event.Kind {
+	case OpCreate:
+		hook = hooks.OnCreate
+	case OpRemove:
+		hook = hooks.OnRemove
+	case OpConnect:
+		hook = hooks.OnConnect
+	}
+	if hook != nil {
+		hook(event.Name)
+	}
+}
+
+// subscribers fans events out to every Subscribe channel.
+type subscribers struct {
+	lock  sync.RWMutex
+	next  int
+	chans map[int]chan Event
+}
+
+// Subscribe returns a channel receiving every subsequent Event and a function that ends the
+// subscription and closes the channel. Delivery never blocks the mutating call: a subscriber
+// that falls eventBuffer events behind misses events until it catches up.
+func (state *State[T]) Subscribe() (<-chan Event, func()) {
+	subs := &state.subs
+	ch := make(chan Event, eventBuffer)
+	subs.lock.Lock()
+	if subs.chans == nil {
+		subs.chans = make(map[int]chan Event)
+	}
+	id := subs.next
+	subs.next++
+	subs.chans[id] = ch
+	subs.lock.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			subs.lock.Lock()
+			delete(subs.chans, id)
+			subs.lock.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish announces a successful mutation: it bumps the op counters, runs the matching hook
+// and offers event to every subscriber without blocking. Holding the read lock while sending keeps unsubscribe
+// from closing a channel mid-send.
+func (state *State[T]) publish(event Event) {
+	state.ops.count(event.Kind)
+	state.Hooks.run(event)
+	subs := &state.subs
+	subs.lock.RLock()
+	defer subs.lock.RUnlock()
+	for _, ch := range subs.chans {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import "testing"
+
+func TestSubscribe(t *testing.T) {
+	st := &State[string]{}
+	events, unsubscribe := st.Subscribe()
+	st.create("A", "")
+	st.create("B", "")
+	st.connect("A", "B", "")
+	st.disconnect("A", "B")
+	st.remove("B")
+	for _, want := range []Event{
+		{OpCreate, "A", ""},
+		{OpCreate, "B", ""},
+		{OpConnect, "A", "B"},
+		{OpDisconnect, "A", "B"},
+		{OpRemove, "B", ""},
+	} {
+		if got := <-events; got != want {
+			t.Fatalf("event = %v, want %v", got, want)
+		}
+	}
+	unsubscribe()
+	unsubscribe()
+	st.create("C", "")
+	if _, open := <-events; open {
+		t.Fatal("channel still open after unsubscribe")
+	}
+	slow, unsubscribeSlow := st.Subscribe()
+	defer unsubscribeSlow()
+	for range 200 {
+		st.update("A", "")
+	}
+	if len(slow) != eventBuffer {
+		t.Fatalf("slow subscriber holds %d events, want a full buffer of %d", len(slow), eventBuffer)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	st := NewState[string](0, 0)
+	var creates, removes, connects int
+	var parents []string
+	st.Hooks = Hooks{
+		OnCreate: func(string) { creates++ },
+		OnRemove: func(string) { removes++ },
+		OnConnect: func(parent string) {
+			connects++
+			parents = append(parents, parent)
+		},
+	}
+	st.createMany(map[string]string{"a": "", "b": "", "c": ""})
+	st.create("a", "")
+	st.connect("a", "b", "")
+	st.connect("a", "c", "")
+	st.connect("a", "a", "")
+	st.remove("c")
+	st.remove("c")
+	st.update("a", "x")
+	if creates != 3 || removes != 1 || connects != 2 || parents[0] != "a" {
+		t.Fatalf("hooks saw %d creates, %d removes, %d connects; want 3, 1, 2", creates, removes, connects)
+	}
+	partial := NewState[string](0, 0)
+	partial.Hooks.OnRemove = func(string) { removes++ }
+	partial.create("q", "")
+	partial.create("r", "")
+	partial.connect("q", "r", "")
+	partial.remove("q")
+	if removes != 2 {
+		t.Fatalf("removes = %d after a remove with only OnRemove set, want 2", removes)
+	}
+}
+
+func TestSwapChildrenPublishes(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "", "a": "", "b": ""})
+	st.connect("P", "a", "x")
+	st.connect("P", "b", "y")
+	var connects int
+	st.Hooks.OnConnect = func(string) { connects++ }
+	events, unsubscribe := st.Subscribe()
+	defer unsubscribe()
+	if err := st.swapChildren("P", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []Event{
+		{OpDisconnect, "P", "a"},
+		{OpDisconnect, "P", "b"},
+		{OpConnect, "P", "a"},
+		{OpConnect, "P", "b"},
+	} {
+		if got := <-events; got != want {
+			t.Fatalf("event = %v, want %v", got, want)
+		}
+	}
+	if connects != 2 {
+		t.Fatalf("OnConnect ran %d times, want 2", connects)
+	}
+	st.swapChildren("P", "a", "a")
+	if len(events) != 0 {
+		t.Fatalf("swapping a child with itself published %d events", len(events))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"slices"
+)
+
+// FrozenState is an immutable snapshot of a State. It holds copies of node texts and
+// child names only, so it has no mutating methods and later changes to the State never show up.
+type FrozenState[T any] struct {
+	views map[string]NodeView[T]
+}
+
+// Freeze snapshots every live node and the edges among them.
+// Like AdjacencyList this is best-effort under concurrent mutation, but every child
+// name in the snapshot is also a node in it.
+func (state *State[T]) Freeze() *FrozenState[T] {
+	frozen := &FrozenState[T]{views: make(map[string]NodeView[T])}
+	state.Range(func(name string, _ *Node[T]) bool {
+		if view, exists := state.describe(name); exists {
+			frozen.views[name] = view
+		}
+		return true
+	})
+	for name, view := range frozen.views {
+		view.Children = slices.DeleteFunc(view.Children, func(child string) bool {
+			_, exists := frozen.views[child]
+			return !exists
+		})
+		frozen.views[name] = view
+	}
+	return frozen
+}
+
+// get returns the frozen view of name. Its Children slice is shared, so callers must not modify it.
+func (frozen *FrozenState[T]) get(name string) (NodeView[T], bool) {
+	view, exists := frozen.views[name]
+	return view, exists
+}
+
+// show is State.show against the snapshot.
+func (frozen *FrozenState[T]) show(name string, format func(T) string) string {
+	view, exists := frozen.views[name]
+	if !exists {
+		return name + " is empty"
+	}
+	return view.format(format)
+}
+
+// walk is State.walk against the snapshot, visiting children in name order.
+func (frozen *FrozenState[T]) walk(start string, visit func(depth int, view NodeView[T]) bool) error {
+	if _, exists := frozen.views[start]; !exists {
+		return ErrNodeNotFound
+	}
+	type frame struct {
+		name  string
+		depth int
+	}
+	visited := make(map[string]bool)
+	stack := []frame{{start, 0}}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[top.name] {
+			continue
+		}
+		visited[top.name] = true
+		view := frozen.views[top.name]
+		if !visit(top.depth, view) {
+			continue
+		}
+		for i := len(view.Children) - 1; i >= 0; i-- {
+			if child := view.Children[i]; !visited[child] {
+				stack = append(stack, frame{child, top.depth + 1})
+			}
+		}
+	}
+	return nil
+}
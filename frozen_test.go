@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "x", "b": "y", "c": "z"})
+	st.connect("a", "b", "")
+	st.connect("b", "c", "")
+	frozen := st.Freeze()
+	before := st.show("a", nil)
+	st.update("a", "changed")
+	st.remove("c")
+	st.create("d", "")
+	st.connect("a", "d", "")
+	if got := frozen.show("a", nil); got != before {
+		t.Fatalf("frozen show = %q, want %q", got, before)
+	}
+	if _, exists := frozen.get("c"); !exists {
+		t.Fatal("frozen graph lost a node removed after Freeze")
+	}
+	var order []string
+	frozen.walk("a", func(_ int, view NodeView[string]) bool {
+		order = append(order, view.Name)
+		return true
+	})
+	if !slices.Equal(order, []string{"a", "b", "c"}) {
+		t.Fatalf("frozen walk = %v, want [a b c]", order)
+	}
+}
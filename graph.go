@@ -0,0 +1,719 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// reaches reports whether target can be reached from start by following child edges.
+// Each node's children are snapshotted under its read lock via getValidChildren,
+// so the walk is safe against concurrent mutation but only reflects a best-effort view.
+func reaches[T any](start, target *Node[T]) bool {
+	visited := map[*Node[T]]bool{start: true}
+	stack := []*Node[T]{start}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node == target {
+			return true
+		}
+		for _, child := range node.getValidChildren() {
+			if !visited[child] {
+				visited[child] = true
+				stack = append(stack, child)
+			}
+		}
+	}
+	return false
+}
+
+// bfs returns every node reachable from start, in breadth-first order.
+// Nodes that die while the walk is in progress are skipped.
+func (state *State[T]) bfs(start string) ([]*Node[T], error) {
+	return state.bfsCtx(context.Background(), start)
+}
+
+// bfsCtx is bfs but returns ctx.Err() as soon as ctx is cancelled.
+func (state *State[T]) bfsCtx(ctx context.Context, start string) ([]*Node[T], error) {
+	return state.bfsBounded(ctx, start, -1)
+}
+
+// bfsDepth is bfs restricted to nodes at most maxDepth hops from start,
+// so a maxDepth of 0 returns just start.
+func (state *State[T]) bfsDepth(start string, maxDepth int) ([]*Node[T], error) {
+	if maxDepth < 0 {
+		return nil, fmt.Errorf("%w: maxDepth must not be negative", ErrInvalidArgument)
+	}
+	return state.bfsBounded(context.Background(), start, maxDepth)
+}
+
+// bfsBounded implements bfsCtx and bfsDepth. A negative maxDepth means no limit.
+func (state *State[T]) bfsBounded(ctx context.Context, start string, maxDepth int) ([]*Node[T], error) {
+	startNode, exists := state.get(start)
+	if !exists {
+		return nil, ErrNodeNotFound
+	}
+	type entry struct {
+		node  *Node[T]
+		depth int
+	}
+	visited := map[string]bool{start: true}
+	queue := []entry{{startNode, 0}}
+	var order []*Node[T]
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		head := queue[0]
+		queue = queue[1:]
+		if head.node.dead.Load() {
+			continue
+		}
+		order = append(order, head.node)
+		if head.depth == maxDepth {
+			continue
+		}
+		for _, child := range head.node.getValidChildren() {
+			if !visited[child.name] {
+				visited[child.name] = true
+				queue = append(queue, entry{child, head.depth + 1})
+			}
+		}
+	}
+	return order, nil
+}
+
+// levels groups the nodes reachable from start by breadth-first distance: levels[0] is
+// [start], levels[1] its children, and so on. Each node appears once, at its shortest
+// distance, so cycles are safe. Names within a level are sorted.
+func (state *State[T]) levels(start string) ([][]string, error) {
+	startNode, exists := state.get(start)
+	if !exists {
+		return nil, ErrNodeNotFound
+	}
+	visited := map[*Node[T]]bool{startNode: true}
+	frontier := []*Node[T]{startNode}
+	var levels [][]string
+	for len(frontier) > 0 {
+		names := make([]string, 0, len(frontier))
+		var next []*Node[T]
+		for _, node := range frontier {
+			names = append(names, node.name)
+			for _, child := range node.getValidChildren() {
+				if !visited[child] {
+					visited[child] = true
+					next = append(next, child)
+				}
+			}
+		}
+		slices.Sort(names)
+		levels = append(levels, names)
+		frontier = next
+	}
+	return levels, nil
+}
+
+// walk performs an iterative depth-first traversal from start, calling visit on each
+// reachable node with its depth (start is depth 0). Returning false from visit prunes
+// that node's subtree. Each node is visited at most once, so cycles are safe.
+func (state *State[T]) walk(start string, visit func(depth int, n *Node[T]) bool) error {
+	return state.walkCtx(context.Background(), start, visit)
+}
+
+// walkCtx is walk but returns ctx.Err() as soon as ctx is cancelled.
+func (state *State[T]) walkCtx(ctx context.Context, start string, visit func(depth int, n *Node[T]) bool) error {
+	startNode, exists := state.get(start)
+	if !exists {
+		return ErrNodeNotFound
+	}
+	type frame struct {
+		node  *Node[T]
+		depth int
+	}
+	visited := make(map[string]bool)
+	stack := []frame{{startNode, 0}}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[top.node.name] || top.node.dead.Load() {
+			continue
+		}
+		visited[top.node.name] = true
+		if !visit(top.depth, top.node) {
+			continue
+		}
+		for _, child := range top.node.getValidChildren() {
+			if !visited[child.name] {
+				stack = append(stack, frame{child, top.depth + 1})
+			}
+		}
+	}
+	return nil
+}
+
+// walkFilter returns start and every node reachable from it through nodes that satisfy keep,
+// in walk order. A rejected node is neither returned nor expanded; start itself is not tested.
+func (state *State[T]) walkFilter(start string, keep func(*Node[T]) bool) ([]*Node[T], error) {
+	var kept []*Node[T]
+	err := state.walk(start, func(depth int, node *Node[T]) bool {
+		if depth > 0 && !keep(node) {
+			return false
+		}
+		kept = append(kept, node)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kept, nil
+}
+
+// shortestPath returns the names along a shortest chain of child edges from `from` to `to`, inclusive.
+func (state *State[T]) shortestPath(from, to string) ([]string, error) {
+	fromNode, fromExists := state.get(from)
+	_, toExists := state.get(to)
+	if !fromExists || !toExists {
+		return nil, ErrNodeNotFound
+	}
+	prev := map[string]string{from: ""}
+	queue := []*Node[T]{fromNode}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node.name == to {
+			var path []string
+			for name := to; name != from; name = prev[name] {
+				path = append(path, name)
+			}
+			path = append(path, from)
+			slices.Reverse(path)
+			return path, nil
+		}
+		for _, child := range node.getValidChildren() {
+			if _, seen := prev[child.name]; !seen {
+				prev[child.name] = node.name
+				queue = append(queue, child)
+			}
+		}
+	}
+	return nil, ErrNoPath
+}
+
+// Stats counts live nodes and the live edges between them.
+// sync.Map has no length and nodes are read one at a time, so under concurrent
+// mutation the result is a best-effort snapshot rather than a consistent cut.
+func (state *State[T]) Stats() (nodes int, edges int) {
+	state.Range(func(_ string, node *Node[T]) bool {
+		nodes++
+		edges += node.liveChildCount()
+		return true
+	})
+	return nodes, edges
+}
+
+// Count returns the number of stored nodes in O(1). The count moves only when an entry is
+// actually stored or deleted, so it cannot drift, but it includes expired nodes that have not
+// been retired yet, and a rename in flight can briefly count its node twice. Stats is exact
+// about liveness at the cost of a full scan.
+func (state *State[T]) Count() int64 {
+	return state.nodes.count.Load()
+}
+
+// childrenOf returns the sorted names of a node's live children.
+func (state *State[T]) childrenOf(name string) ([]string, error) {
+	node, exists := state.get(name)
+	if !exists {
+		return nil, ErrNodeNotFound
+	}
+	names := []string{}
+	for _, child := range node.getValidChildren() {
+		names = append(names, child.name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// hasEdge reports whether parent has a live edge to child. A pointer to a removed child
+// does not count. Only the parent has to exist.
+func (state *State[T]) hasEdge(parent, child string) (bool, error) {
+	parentNode, exists := state.get(parent)
+	if !exists {
+		return false, ErrNodeNotFound
+	}
+	return parentNode.child(child) != nil, nil
+}
+
+// childrenPage returns at most limit of a node's sorted live child names, starting at offset.
+// An offset past the end yields an empty page rather than an error.
+func (state *State[T]) childrenPage(name string, offset, limit int) ([]string, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("%w: offset and limit must not be negative", ErrInvalidArgument)
+	}
+	names, err := state.childrenOf(name)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= len(names) {
+		return []string{}, nil
+	}
+	return names[offset:min(offset+limit, len(names))], nil
+}
+
+// AdjacencyList snapshots the graph as node name -> sorted live child names, with an empty
+// list for leaves. Edges to children that were removed before their own entry was read are
+// dropped, so every name in a child list is also a key.
+func (state *State[T]) AdjacencyList() map[string][]string {
+	adj := make(map[string][]string)
+	state.Range(func(name string, node *Node[T]) bool {
+		children := []string{}
+		for _, child := range node.getValidChildren() {
+			children = append(children, child.name)
+		}
+		slices.Sort(children)
+		adj[name] = children
+		return true
+	})
+	for name, children := range adj {
+		adj[name] = slices.DeleteFunc(children, func(child string) bool {
+			_, exists := adj[child]
+			return !exists
+		})
+	}
+	return adj
+}
+
+// topoSort orders node names so every parent precedes its children, using Kahn's algorithm.
+// Ties are broken by name, so the result is deterministic for a given graph.
+func (state *State[T]) topoSort() ([]string, error) {
+	adj := state.AdjacencyList()
+	inDegree := make(map[string]int, len(adj))
+	for _, children := range adj {
+		for _, child := range children {
+			inDegree[child]++
+		}
+	}
+	var ready []string
+	for name := range adj {
+		if inDegree[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	slices.Sort(ready)
+	order := make([]string, 0, len(adj))
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, child := range adj[name] {
+			inDegree[child]--
+			if inDegree[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+	if len(order) != len(adj) {
+		return nil, ErrCycle
+	}
+	return order, nil
+}
+
+// roots returns the sorted names of nodes that are no live node's child.
+func (state *State[T]) roots() []string {
+	adj := state.AdjacencyList()
+	isChild := make(map[string]bool)
+	for _, children := range adj {
+		for _, child := range children {
+			isChild[child] = true
+		}
+	}
+	var names []string
+	for name := range adj {
+		if !isChild[name] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// leaves returns the sorted names of nodes with no live children.
+// A node whose children have all been removed counts as a leaf.
+func (state *State[T]) leaves() []string {
+	var names []string
+	state.Range(func(name string, node *Node[T]) bool {
+		if node.liveChildCount() == 0 {
+			names = append(names, name)
+		}
+		return true
+	})
+	slices.Sort(names)
+	return names
+}
+
+// subtreeVictims returns the nodes removeSubtree(name) would delete: start plus everything
+// reachable from it that is not also reachable from outside. Survivors are found through
+// parent back-references: any reachable node with a live parent outside the reachable set
+// is kept, along with everything it reaches without going back through start.
+// children and parents list a node's live neighbours, see removeSubtree and removeDryRun.
+func (state *State[T]) subtreeVictims(start *Node[T], children, parents func(*Node[T]) []*Node[T]) []*Node[T] {
+	reachable := map[*Node[T]]bool{start: true}
+	stack := []*Node[T]{start}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, child := range children(node) {
+			if !reachable[child] {
+				reachable[child] = true
+				stack = append(stack, child)
+			}
+		}
+	}
+	kept := make(map[*Node[T]]bool)
+	for node := range reachable {
+		if node == start {
+			continue
+		}
+		for _, parent := range parents(node) {
+			if !reachable[parent] && !parent.dead.Load() {
+				kept[node] = true
+				stack = append(stack, node)
+				break
+			}
+		}
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, child := range children(node) {
+			if child != start && !kept[child] {
+				kept[child] = true
+				stack = append(stack, child)
+			}
+		}
+	}
+	var victims []*Node[T]
+	for node := range reachable {
+		if !kept[node] {
+			victims = append(victims, node)
+		}
+	}
+	return victims
+}
+
+// removeSubtree removes name and every node that is only reachable through it,
+// returning the sorted names of the removed nodes. Nodes also reachable from elsewhere survive.
+func (state *State[T]) removeSubtree(name string) ([]string, error) {
+	start, exists := state.get(name)
+	if !exists {
+		return nil, ErrNodeNotFound
+	}
+	var removed []string
+	for _, node := range state.subtreeVictims(start, (*Node[T]).getValidChildren, (*Node[T]).getParents) {
+		if _, err := state.remove(node.name); err == nil {
+			removed = append(removed, node.name)
+		}
+	}
+	slices.Sort(removed)
+	return removed, nil
+}
+
+// removeDryRun returns the sorted names removeSubtree(name) would remove, without removing
+// anything. The walk only reads, leaving even dead pointers in place for the usual cleanup.
+// Concurrent mutation between the preview and a real removeSubtree can change the answer.
+func (state *State[T]) removeDryRun(name string) (affected []string, err error) {
+	start, exists := state.get(name)
+	if !exists {
+		return nil, ErrNodeNotFound
+	}
+	for _, node := range state.subtreeVictims(start, (*Node[T]).peekChildren, (*Node[T]).peekParents) {
+		affected = append(affected, node.name)
+	}
+	slices.Sort(affected)
+	return affected, nil
+}
+
+// canReach reports whether `to` is reachable from `from` along child edges. A node reaches itself.
+func (state *State[T]) canReach(from, to string) (bool, error) {
+	fromNode, fromExists := state.get(from)
+	toNode, toExists := state.get(to)
+	if !fromExists || !toExists {
+		return false, ErrNodeNotFound
+	}
+	return reaches(fromNode, toNode), nil
+}
+
+// reachableCount returns how many distinct nodes are reachable from start, start included.
+// It is len(bfs(start)) without collecting the nodes.
+func (state *State[T]) reachableCount(start string) (int, error) {
+	startNode, exists := state.get(start)
+	if !exists {
+		return 0, ErrNodeNotFound
+	}
+	visited := map[*Node[T]]bool{startNode: true}
+	stack := []*Node[T]{startNode}
+	count := 0
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		count++
+		for _, child := range node.getValidChildren() {
+			if !visited[child] {
+				visited[child] = true
+				stack = append(stack, child)
+			}
+		}
+	}
+	return count, nil
+}
+
+// unionFind is a disjoint-set forest over node names.
+type unionFind map[string]string
+
+// find returns the representative of name's set, compressing the path as it goes.
+func (uf unionFind) find(name string) string {
+	for uf[name] != name {
+		uf[name] = uf[uf[name]]
+		name = uf[name]
+	}
+	return name
+}
+
+// union merges the sets containing a and b.
+func (uf unionFind) union(a, b string) {
+	uf[uf.find(a)] = uf.find(b)
+}
+
+// weaklyConnectedComponents groups node names into components, treating every edge as undirected.
+// Both child edges and parent back-references are followed. Each component is sorted,
+// and components are ordered by their first name.
+func (state *State[T]) weaklyConnectedComponents() [][]string {
+	adj := state.AdjacencyList()
+	uf := make(unionFind, len(adj))
+	for name := range adj {
+		uf[name] = name
+	}
+	for name, children := range adj {
+		for _, child := range children {
+			uf.union(name, child)
+		}
+		if node, exists := state.get(name); exists {
+			for _, parent := range node.getParents() {
+				if _, known := adj[parent.name]; known {
+					uf.union(name, parent.name)
+				}
+			}
+		}
+	}
+	groups := make(map[string][]string)
+	for name := range adj {
+		root := uf.find(name)
+		groups[root] = append(groups[root], name)
+	}
+	components := make([][]string, 0, len(groups))
+	for _, group := range groups {
+		slices.Sort(group)
+		components = append(components, group)
+	}
+	slices.SortFunc(components, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+	return components
+}
+
+// depth returns the length of the longest chain of child edges from any root down to name,
+// so a root has depth 0. It fails if name sits below a cycle, where depth is unbounded.
+func (state *State[T]) depth(name string) (int, error) {
+	adj := state.AdjacencyList()
+	if _, exists := adj[name]; !exists {
+		return 0, ErrNodeNotFound
+	}
+	parents := make(map[string][]string, len(adj))
+	for parent, children := range adj {
+		for _, child := range children {
+			parents[child] = append(parents[child], parent)
+		}
+	}
+	memo := make(map[string]int)
+	onPath := make(map[string]bool)
+	var longest func(name string) (int, error)
+	longest = func(name string) (int, error) {
+		if d, done := memo[name]; done {
+			return d, nil
+		}
+		if onPath[name] {
+			return 0, ErrCycle
+		}
+		onPath[name] = true
+		d := 0
+		for _, parent := range parents[name] {
+			pd, err := longest(parent)
+			if err != nil {
+				return 0, err
+			}
+			d = max(d, pd+1)
+		}
+		onPath[name] = false
+		memo[name] = d
+		return d, nil
+	}
+	return longest(name)
+}
+
+// reachableFrom marks every name in adj reachable from starts, starts included.
+// Starts that are not in adj are ignored.
+func reachableFrom(adj map[string][]string, starts []string) map[string]bool {
+	reached := make(map[string]bool, len(adj))
+	var stack []string
+	for _, start := range starts {
+		if _, exists := adj[start]; exists && !reached[start] {
+			reached[start] = true
+			stack = append(stack, start)
+		}
+	}
+	for len(stack) > 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, child := range adj[name] {
+			if !reached[child] {
+				reached[child] = true
+				stack = append(stack, child)
+			}
+		}
+	}
+	return reached
+}
+
+// orphans returns the sorted names of nodes that no root reaches. Detaching a plain subtree
+// just makes its top a new root, so what is left is cycles that lost every edge in from
+// a root, together with everything hanging off them.
+func (state *State[T]) orphans() []string {
+	adj := state.AdjacencyList()
+	reached := reachableFrom(adj, state.roots())
+	names := []string{}
+	for name := range adj {
+		if !reached[name] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// gcUnreachable removes every node that none of roots reaches and returns the sorted
+// names it removed. Removal marks each node dead, so edges to it are pruned lazily as usual.
+func (state *State[T]) gcUnreachable(roots []string) []string {
+	adj := state.AdjacencyList()
+	reached := reachableFrom(adj, roots)
+	removed := []string{}
+	for name := range adj {
+		if reached[name] {
+			continue
+		}
+		if _, err := state.remove(name); err == nil {
+			removed = append(removed, name)
+		}
+	}
+	slices.Sort(removed)
+	return removed
+}
+
+// findCycles reports a cycle for every back edge a depth-first search finds, each as the
+// names along the loop rotated to start at its smallest name. Every cyclic part of the graph
+// shows up at least once, but overlapping loops that share a back edge are reported only once,
+// so this is a diagnostic rather than an enumeration of every elementary cycle.
+func (state *State[T]) findCycles() [][]string {
+	adj := state.AdjacencyList()
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+	status := make(map[string]int, len(adj))
+	var stack []string
+	var cycles [][]string
+	var visit func(name string)
+	visit = func(name string) {
+		status[name] = onStack
+		stack = append(stack, name)
+		for _, child := range adj[name] {
+			switch status[child] {
+			case unvisited:
+				visit(child)
+			case onStack:
+				loop := slices.Clone(stack[slices.Index(stack, child):])
+				smallest := slices.Index(loop, slices.Min(loop))
+				cycles = append(cycles, append(loop[smallest:], loop[:smallest]...))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		status[name] = done
+	}
+	for _, name := range slices.Sorted(maps.Keys(adj)) {
+		if status[name] == unvisited {
+			visit(name)
+		}
+	}
+	slices.SortFunc(cycles, slices.Compare)
+	return cycles
+}
+
+// ancestors returns every node reachable from start by following parent back-references,
+// start excluded unless it sits on a cycle through itself.
+func ancestors[T any](start *Node[T]) map[*Node[T]]bool {
+	seen := make(map[*Node[T]]bool)
+	stack := []*Node[T]{start}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, parent := range node.getParents() {
+			if !seen[parent] && !parent.dead.Load() {
+				seen[parent] = true
+				stack = append(stack, parent)
+			}
+		}
+	}
+	return seen
+}
+
+// commonAncestors returns the sorted names of nodes that are ancestors of both a and b,
+// found through parent back-references, so every parent of a node with several is followed.
+func (state *State[T]) commonAncestors(a, b string) ([]string, error) {
+	aNode, aExists := state.get(a)
+	bNode, bExists := state.get(b)
+	if !aExists || !bExists {
+		return nil, ErrNodeNotFound
+	}
+	ofB := ancestors(bNode)
+	names := []string{}
+	for node := range ancestors(aNode) {
+		if ofB[node] {
+			names = append(names, node.name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// degrees maps each node name to [inDegree, outDegree], counted over one AdjacencyList snapshot
+// so both halves agree on the same set of live edges.
+func (state *State[T]) degrees() map[string][2]int {
+	adj := state.AdjacencyList()
+	deg := make(map[string][2]int, len(adj))
+	for name, children := range adj {
+		d := deg[name]
+		d[1] = len(children)
+		deg[name] = d
+		for _, child := range children {
+			c := deg[child]
+			c[0]++
+			deg[child] = c
+		}
+	}
+	return deg
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBFS(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"A", "B", "C", "D"} {
+		st.create(n, n)
+	}
+	st.connect("A", "B", "")
+	st.connect("B", "C", "")
+	st.connect("C", "A", "")
+	st.connect("A", "D", "")
+	got, err := st.bfs("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[0].name != "A" || got[3].name != "C" {
+		t.Fatalf("bfs(A) visited %v, want A first and C last of four", nodeNames(got))
+	}
+	if _, err := st.bfs("Z"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("bfs from a missing node = %v, want ErrNodeNotFound", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		st.remove("C")
+	}()
+	st.bfs("A")
+	wg.Wait()
+}
+
+func TestWalk(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"A", "B", "C", "D"} {
+		st.create(n, n)
+	}
+	st.connect("A", "B", "")
+	st.connect("B", "C", "")
+	st.connect("A", "D", "")
+	depths := map[string]int{}
+	st.walk("A", func(depth int, n *Node[string]) bool {
+		depths[n.name] = depth
+		return n.name != "B"
+	})
+	if want := map[string]int{"A": 0, "B": 1, "D": 1}; !maps.Equal(depths, want) {
+		t.Fatalf("walk depths = %v, want %v (C pruned below B)", depths, want)
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"A", "B", "C", "D", "E"} {
+		st.create(n, n)
+	}
+	st.connect("A", "B", "")
+	st.connect("B", "C", "")
+	st.connect("C", "D", "")
+	st.connect("A", "E", "")
+	st.connect("E", "D", "")
+	if p, err := st.shortestPath("A", "D"); err != nil || !slices.Equal(p, []string{"A", "E", "D"}) {
+		t.Fatalf("shortestPath(A, D) = %v, %v; want [A E D]", p, err)
+	}
+	if p, _ := st.shortestPath("B", "B"); !slices.Equal(p, []string{"B"}) {
+		t.Fatalf("shortestPath(B, B) = %v, want [B]", p)
+	}
+	if _, err := st.shortestPath("D", "A"); !errors.Is(err, ErrNoPath) {
+		t.Fatalf("shortestPath(D, A) = %v, want ErrNoPath", err)
+	}
+	if _, err := st.shortestPath("Q", "A"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("shortestPath from a missing node = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"A", "B", "C"} {
+		st.create(n, n)
+	}
+	st.connect("A", "B", "")
+	st.connect("A", "C", "")
+	st.connect("B", "C", "")
+	if n, e := st.Stats(); n != 3 || e != 3 {
+		t.Fatalf("Stats = %d nodes, %d edges; want 3, 3", n, e)
+	}
+	st.remove("C")
+	if n, e := st.Stats(); n != 2 || e != 1 {
+		t.Fatalf("Stats after removing C = %d nodes, %d edges; want 2, 1", n, e)
+	}
+}
+
+func TestTraversalsHonourCancellation(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := st.bfsCtx(ctx, "A"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("bfsCtx = %v, want context.Canceled", err)
+	}
+	if err := st.walkCtx(ctx, "A", func(int, *Node[string]) bool { return true }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("walkCtx = %v, want context.Canceled", err)
+	}
+}
+
+func TestChildrenOf(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"P": "", "c": "", "a": "", "b": ""})
+	st.connectMany("P", []string{"c", "a", "b"}, "")
+	st.remove("b")
+	if got, err := st.childrenOf("P"); err != nil || !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("childrenOf(P) = %v, %v; want [a c]", got, err)
+	}
+	if _, err := st.childrenOf("Z"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("childrenOf a missing node = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestTopoSort(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "", "B": "", "C": "", "D": "", "E": ""})
+	st.connect("A", "B", "")
+	st.connect("A", "C", "")
+	st.connect("C", "B", "")
+	st.connect("B", "D", "")
+	got, err := st.topoSort()
+	if err != nil || len(got) != 5 {
+		t.Fatalf("topoSort = %v, %v; want all five nodes", got, err)
+	}
+	pos := map[string]int{}
+	for i, n := range got {
+		pos[n] = i
+	}
+	if !(pos["A"] < pos["C"] && pos["C"] < pos["B"] && pos["B"] < pos["D"]) {
+		t.Fatalf("topoSort = %v breaks A < C < B < D", got)
+	}
+	st.connect("D", "A", "")
+	if _, err := st.topoSort(); !errors.Is(err, ErrCycle) {
+		t.Fatalf("topoSort of a cyclic graph = %v, want ErrCycle", err)
+	}
+}
+
+func TestRoots(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "", "B": "", "C": "", "D": "", "X": ""})
+	st.connect("A", "B", "")
+	st.connect("C", "D", "")
+	st.connect("X", "D", "")
+	st.remove("X")
+	if got := st.roots(); !slices.Equal(got, []string{"A", "C"}) {
+		t.Fatalf("roots = %v, want [A C]", got)
+	}
+}
+
+func TestLeaves(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "", "B": "", "C": "", "D": ""})
+	st.connect("A", "B", "")
+	st.connect("B", "C", "")
+	st.connect("D", "C", "")
+	st.remove("C")
+	if got := st.leaves(); !slices.Equal(got, []string{"B", "D"}) {
+		t.Fatalf("leaves = %v, want [B D]", got)
+	}
+}
+
+func TestRemoveSubtree(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"R": "", "A": "", "B": "", "C": "", "S": "", "O": "", "X": "", "Y": ""})
+	st.connect("R", "A", "")
+	st.connect("A", "B", "")
+	st.connect("A", "S", "")
+	st.connect("O", "S", "")
+	st.connect("S", "C", "")
+	st.connect("B", "X", "")
+	st.connect("X", "Y", "")
+	st.connect("Y", "X", "")
+	if got, err := st.removeSubtree("A"); err != nil || !slices.Equal(got, []string{"A", "B", "X", "Y"}) {
+		t.Fatalf("removeSubtree(A) = %v, %v; want [A B X Y]", got, err)
+	}
+	for _, n := range []string{"S", "C", "R", "O"} {
+		if _, exists := st.get(n); !exists {
+			t.Errorf("%s was removed but is reachable from outside the subtree", n)
+		}
+	}
+}
+
+func TestCanReach(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "", "B": "", "C": ""})
+	st.connect("A", "B", "")
+	st.connect("B", "A", "")
+	for _, c := range []struct {
+		from, to string
+		want     bool
+	}{
+		{"A", "B", true},
+		{"A", "C", false},
+		{"C", "C", true},
+	} {
+		if got, err := st.canReach(c.from, c.to); err != nil || got != c.want {
+			t.Errorf("canReach(%s, %s) = %v, %v; want %v", c.from, c.to, got, err, c.want)
+		}
+	}
+	if _, err := st.canReach("Q", "C"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("canReach from a missing node = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestWeaklyConnectedComponents(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "", "B": "", "C": "", "X": "", "Y": "", "Z": ""})
+	st.connect("A", "B", "")
+	st.connect("C", "B", "")
+	st.connect("Y", "X", "")
+	want := [][]string{{"A", "B", "C"}, {"X", "Y"}, {"Z"}}
+	if got := st.weaklyConnectedComponents(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Fatalf("components = %v, want %v", got, want)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "a": "", "b": "", "c": "", "x": "", "y": ""})
+	st.connect("r", "a", "")
+	st.connect("a", "b", "")
+	st.connect("b", "c", "")
+	st.connect("r", "c", "")
+	st.connect("x", "y", "")
+	st.connect("y", "x", "")
+	for name, want := range map[string]int{"r": 0, "a": 1, "b": 2, "c": 3} {
+		if got, err := st.depth(name); err != nil || got != want {
+			t.Errorf("depth(%s) = %d, %v; want %d", name, got, err, want)
+		}
+	}
+	if _, err := st.depth("x"); !errors.Is(err, ErrCycle) {
+		t.Fatalf("depth inside a rootless cycle = %v, want ErrCycle", err)
+	}
+	if _, err := st.depth("zz"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("depth of a missing node = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "a": "", "b": "", "c": ""})
+	st.connect("r", "a", "")
+	st.connect("a", "b", "")
+	st.connect("b", "a", "")
+	st.connect("b", "c", "")
+	if got := st.orphans(); len(got) != 0 {
+		t.Fatalf("orphans = %v, want none", got)
+	}
+	st.disconnect("r", "a")
+	if got := st.orphans(); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("orphans = %v, want [a b c]", got)
+	}
+	if _, exists := st.get("a"); !exists {
+		t.Fatal("orphans removed a node")
+	}
+}
+
+func TestGCUnreachable(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "a": "", "b": "", "c": "", "d": ""})
+	st.connect("r", "a", "")
+	st.connect("a", "b", "")
+	st.connect("r", "c", "")
+	st.connect("c", "d", "")
+	st.disconnect("r", "c")
+	c, _ := st.get("c")
+	if got := st.gcUnreachable([]string{"r"}); !slices.Equal(got, []string{"c", "d"}) || !c.dead.Load() {
+		t.Fatalf("gcUnreachable = %v, want [c d] removed", got)
+	}
+	if n, _ := st.Stats(); n != 3 {
+		t.Fatalf("%d nodes left, want 3", n)
+	}
+}
+
+func TestChildrenPage(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.create("P", "")
+	for i := range 200 {
+		n := fmt.Sprintf("c%03d", i)
+		st.create(n, "")
+		st.connect("P", n, "")
+	}
+	var all []string
+	for offset := 0; offset < 200; offset += 50 {
+		page, err := st.childrenPage("P", offset, 50)
+		if err != nil || len(page) != 50 {
+			t.Fatalf("childrenPage(P, %d, 50) returned %d names, %v", offset, len(page), err)
+		}
+		all = append(all, page...)
+	}
+	if len(all) != 200 || !slices.IsSorted(all) || all[0] != "c000" {
+		t.Fatalf("pages joined into %d names, want 200 sorted from c000", len(all))
+	}
+	if page, err := st.childrenPage("P", 500, 50); err != nil || page == nil || len(page) != 0 {
+		t.Fatalf("page past the end = %v, %v; want an empty slice", page, err)
+	}
+	if _, err := st.childrenPage("P", -1, 50); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("negative offset = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestAdjacencyList(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "", "b": "", "c": "", "d": ""})
+	st.connect("a", "c", "")
+	st.connect("a", "b", "")
+	st.connect("b", "d", "")
+	st.connect("c", "d", "")
+	st.create("e", "")
+	st.connect("a", "e", "")
+	st.remove("e")
+	want := map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}, "d": {}}
+	if got := st.AdjacencyList(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("AdjacencyList = %v, want %v", got, want)
+	}
+}
+
+func TestReachableCount(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "a": "", "b": "", "s": "", "t": "", "x": ""})
+	st.connect("r", "a", "")
+	st.connect("r", "b", "")
+	st.connect("a", "s", "")
+	st.connect("b", "s", "")
+	st.connect("s", "t", "")
+	if n, err := st.reachableCount("r"); err != nil || n != 5 {
+		t.Fatalf("reachableCount(r) = %d, %v; want 5", n, err)
+	}
+	if n, _ := st.reachableCount("x"); n != 1 {
+		t.Fatalf("reachableCount(x) = %d, want 1", n)
+	}
+}
+
+func TestFindCycles(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "a": "", "b": "", "c": "", "x": "", "y": "", "s": ""})
+	st.connect("r", "b", "")
+	st.connect("b", "c", "")
+	st.connect("c", "a", "")
+	st.connect("a", "b", "")
+	st.connect("r", "s", "")
+	st.connect("x", "y", "")
+	st.connect("y", "x", "")
+	st.connect("y", "s", "")
+	want := [][]string{{"a", "b", "c"}, {"x", "y"}}
+	if got := st.findCycles(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("findCycles = %v, want %v", got, want)
+	}
+}
+
+func TestBFSDepth(t *testing.T) {
+	st := NewState[string](0, 0)
+	for i := range 6 {
+		st.create(strconv.Itoa(i), "")
+		if i > 0 {
+			st.connect(strconv.Itoa(i-1), strconv.Itoa(i), "")
+		}
+	}
+	for _, c := range []struct{ depth, want int }{{0, 1}, {1, 2}, {2, 3}, {3, 4}, {100, 6}} {
+		got, err := st.bfsDepth("0", c.depth)
+		if err != nil || len(got) != c.want || got[len(got)-1].name != strconv.Itoa(c.want-1) {
+			t.Fatalf("bfsDepth(0, %d) returned %d nodes, %v; want %d", c.depth, len(got), err, c.want)
+		}
+	}
+	if _, err := st.bfsDepth("0", -1); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("negative depth = %v, want ErrInvalidArgument", err)
+	}
+	if all, _ := st.bfs("0"); len(all) != 6 {
+		t.Fatalf("bfs returned %d nodes, want 6", len(all))
+	}
+}
+
+func TestWalkFilter(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "root", "a": "doc:a", "b": "img:b", "c": "doc:c", "d": "doc:d"})
+	st.connect("r", "a", "")
+	st.connect("r", "b", "")
+	st.connect("a", "c", "")
+	st.connect("b", "d", "")
+	got, err := st.walkFilter("r", func(n *Node[string]) bool { return strings.HasPrefix(n.getText(), "doc:") })
+	if names := nodeNames(got); err != nil || !slices.Equal(names, []string{"a", "c", "r"}) {
+		t.Fatalf("walkFilter = %v, %v; want [a c r]", names, err)
+	}
+}
+
+func TestRemoveDryRun(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "a": "", "b": "", "c": "", "o": ""})
+	st.connect("r", "a", "")
+	st.connect("a", "b", "")
+	st.connect("a", "c", "")
+	st.connect("o", "c", "")
+	before, _ := st.MarshalJSON()
+	preview, err := st.removeDryRun("a")
+	if after, _ := st.MarshalJSON(); err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("removeDryRun changed the graph or failed: %v", err)
+	}
+	removed, _ := st.removeSubtree("a")
+	if !slices.Equal(preview, removed) || !slices.Equal(preview, []string{"a", "b"}) {
+		t.Fatalf("preview %v, removed %v; want both [a b]", preview, removed)
+	}
+}
+
+func TestHasEdge(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "", "a": "", "b": "", "c": ""})
+	st.connect("P", "a", "")
+	st.connect("P", "b", "")
+	st.remove("b")
+	for child, want := range map[string]bool{"a": true, "b": false, "c": false, "zz": false} {
+		if got, err := st.hasEdge("P", child); err != nil || got != want {
+			t.Errorf("hasEdge(P, %s) = %v, %v; want %v", child, got, err, want)
+		}
+	}
+	if _, err := st.hasEdge("nope", "a"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("hasEdge from a missing node = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestCountMatchesStats(t *testing.T) {
+	for _, st := range []*State[string]{NewState[string](0, 0), NewShardedState[string](0, 0, 4)} {
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 100 {
+					st.create(strconv.Itoa(i), "")
+					st.remove(strconv.Itoa(i + 50))
+				}
+			}()
+		}
+		wg.Wait()
+		if n, _ := st.Stats(); st.Count() != int64(n) {
+			t.Fatalf("Count = %d, Stats = %d", st.Count(), n)
+		}
+		st.rename("1", "one")
+		st.remove("zz")
+		st.createWithTTL("ttl", "", time.Millisecond)
+		time.Sleep(3 * time.Millisecond)
+		st.get("ttl")
+		if n, _ := st.Stats(); st.Count() != int64(n) {
+			t.Fatalf("after rename and expiry Count = %d, Stats = %d", st.Count(), n)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "a": "", "b": "", "c": "", "d": "", "e": ""})
+	st.connect("r", "b", "")
+	st.connect("r", "a", "")
+	st.connect("a", "c", "")
+	st.connect("b", "d", "")
+	st.connect("d", "e", "")
+	st.connect("e", "r", "")
+	st.connect("b", "c", "")
+	want := [][]string{{"r"}, {"a", "b"}, {"c", "d"}, {"e"}}
+	if got, err := st.levels("r"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("levels(r) = %v, %v; want %v", got, err, want)
+	}
+}
+
+func TestCommonAncestors(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"top": "", "l": "", "r": "", "x": "", "y": "", "o": ""})
+	st.connect("top", "l", "")
+	st.connect("top", "r", "")
+	st.connect("l", "x", "")
+	st.connect("r", "y", "")
+	st.connect("l", "y", "")
+	st.connect("o", "x", "")
+	if got, err := st.commonAncestors("x", "y"); err != nil || !slices.Equal(got, []string{"l", "top"}) {
+		t.Fatalf("commonAncestors(x, y) = %v, %v; want [l top]", got, err)
+	}
+	if got, _ := st.commonAncestors("l", "r"); !slices.Equal(got, []string{"top"}) {
+		t.Fatalf("commonAncestors(l, r) = %v, want [top]", got)
+	}
+	if _, err := st.commonAncestors("x", "zz"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("commonAncestors with a missing node = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestDegrees(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"h": "", "a": "", "b": "", "c": "", "p": "", "q": ""})
+	st.connect("p", "h", "")
+	st.connect("q", "h", "x")
+	for _, c := range []string{"a", "b", "c"} {
+		st.connect("h", c, "")
+	}
+	st.connect("h", "a", "extra")
+	st.create("gone", "")
+	st.connect("h", "gone", "")
+	st.remove("gone")
+	d := st.degrees()
+	if d["h"] != [2]int{2, 3} || d["a"] != [2]int{1, 0} || d["p"] != [2]int{0, 1} || len(d) != 6 {
+		t.Fatalf("degrees = %v", d)
+	}
+}
+
+func TestRemoveDryRunLeavesDeadPointersAlone(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"P", "C", "D"} {
+		st.create(n, n)
+	}
+	st.connect("P", "C", "")
+	st.connect("P", "D", "")
+	st.connect("D", "P", "")
+	st.remove("C")
+	p, _ := st.get("P")
+	d, _ := st.get("D")
+	affected, err := st.removeDryRun("P")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(affected, []string{"D", "P"}) {
+		t.Fatalf("affected = %v, want [D P]", affected)
+	}
+	if p.pendingDead() != 1 || p.CleanupCounter() != 0 {
+		t.Fatalf("dry run reset a child pointer: pending %d, counter %d", p.pendingDead(), p.CleanupCounter())
+	}
+	st.remove("P")
+	st.removeDryRun("D")
+	if ptr := d.parents["P"]; ptr == nil || ptr.Load() == nil {
+		t.Fatal("dry run reset a parent pointer")
+	}
+}
+
+func TestOrphansAfterDetachingSubtree(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "x": "", "y": "", "s": "", "t": "", "p": "", "q": ""})
+	st.connect("r", "x", "")
+	st.connect("x", "y", "")
+	st.connect("y", "x", "")
+	st.connect("y", "s", "")
+	st.connect("s", "t", "")
+	st.connect("r", "p", "")
+	st.connect("p", "q", "")
+	st.disconnect("r", "p")
+	if got := st.orphans(); len(got) != 0 {
+		t.Fatalf("orphans after detaching the plain subtree p = %v, want none since p is now a root", got)
+	}
+	st.disconnect("r", "x")
+	if got := st.orphans(); !slices.Equal(got, []string{"s", "t", "x", "y"}) {
+		t.Fatalf("orphans after detaching x = %v, want the cycle and the subtree under it", got)
+	}
+	for _, n := range []string{"s", "t"} {
+		if _, exists := st.get(n); !exists {
+			t.Fatalf("orphaned %s was removed", n)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// HistoryState wraps a State so that every mutation made through it can be undone and redone.
+// Mutations made on the underlying State directly are not recorded, and undoing past them
+// may fail or produce surprising results.
+type HistoryState[T any] struct {
+	state *State[T]
+	lock  sync.Mutex
+	undo  []historyEntry
+	redo  []historyEntry
+}
+
+// historyEntry is one recorded mutation: apply redoes it and revert undoes it.
+type historyEntry struct {
+	apply  func() error
+	revert func() error
+}
+
+// WithHistory returns a HistoryState recording mutations made through it on state.
+func (state *State[T]) WithHistory() *HistoryState[T] {
+	return &HistoryState[T]{state: state}
+}
+
+// State returns the wrapped State for reads.
+func (history *HistoryState[T]) State() *State[T] {
+	return history.state
+}
+
+// record runs entry.apply and, if it succeeds, pushes entry onto the undo stack
+// and discards anything that could have been redone.
+func (history *HistoryState[T]) record(entry historyEntry) error {
+	if err := entry.apply(); err != nil {
+		return err
+	}
+	history.undo = append(history.undo, entry)
+	history.redo = nil
+	return nil
+}
+
+// Undo reverts the most recent recorded mutation. If reverting fails, both stacks are left as they were.
+func (history *HistoryState[T]) Undo() error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	if len(history.undo) == 0 {
+		return ErrNothingToUndo
+	}
+	entry := history.undo[len(history.undo)-1]
+	if err := entry.revert(); err != nil {
+		return err
+	}
+	history.undo = history.undo[:len(history.undo)-1]
+	history.redo = append(history.redo, entry)
+	return nil
+}
+
+// Redo reapplies the most recently undone mutation. If that fails, both stacks are left as they were.
+func (history *HistoryState[T]) Redo() error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	if len(history.redo) == 0 {
+		return ErrNothingToRedo
+	}
+	entry := history.redo[len(history.redo)-1]
+	if err := entry.apply(); err != nil {
+		return err
+	}
+	history.redo = history.redo[:len(history.redo)-1]
+	history.undo = append(history.undo, entry)
+	return nil
+}
+
+// create is State.create; undoing it removes the node.
+func (history *HistoryState[T]) create(name string, text T) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	state := history.state
+	return history.record(historyEntry{
+		apply:  func() error { return state.create(name, text) },
+		revert: func() error { _, err := state.remove(name); return err },
+	})
+}
+
+// remove is State.remove; undoing it recreates the node with its text and edges.
+func (history *HistoryState[T]) remove(name string) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	state := history.state
+	var stone *tombstone[T]
+	return history.record(historyEntry{
+		apply: func() (err error) {
+			stone, err = state.removeEntombed(name)
+			return err
+		},
+		revert: func() error { return state.rebuild(name, stone) },
+	})
+}
+
+// update is State.update; undoing it puts the previous text back.
+func (history *HistoryState[T]) update(name string, text T) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	state := history.state
+	var previous T
+	return history.record(historyEntry{
+		apply: func() error {
+			node, exists := state.get(name)
+			if !exists {
+				return ErrNodeNotFound
+			}
+			previous = node.getText()
+			return state.update(name, text)
+		},
+		revert: func() error { return state.update(name, previous) },
+	})
+}
+
+// rename is State.rename; undoing it renames the node back.
+func (history *HistoryState[T]) rename(oldName, newName string) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	state := history.state
+	return history.record(historyEntry{
+		apply:  func() error { return state.rename(oldName, newName) },
+		revert: func() error { return state.rename(newName, oldName) },
+	})
+}
+
+// connect is State.connect; undoing it restores the edge to the labels it had before, or removes it.
+func (history *HistoryState[T]) connect(parent, child, label string) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	state := history.state
+	var previous []string
+	return history.record(historyEntry{
+		apply: func() error {
+			previous = state.labelsOf(parent, child)
+			return state.connect(parent, child, label)
+		},
+		revert: func() error { return state.setLabels(parent, child, previous) },
+	})
+}
+
+// disconnect is State.disconnect; undoing it reconnects the edge with all of its labels.
+func (history *HistoryState[T]) disconnect(parent, child string) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	state := history.state
+	var previous []string
+	return history.record(historyEntry{
+		apply: func() error {
+			previous = state.labelsOf(parent, child)
+			return state.disconnect(parent, child)
+		},
+		revert: func() error { return state.setLabels(parent, child, previous) },
+	})
+}
+
+// labelsOf returns the labels on the live edge parent -> child, or nil if there is none.
+func (state *State[T]) labelsOf(parent, child string) []string {
+	parentNode, parentExists := state.get(parent)
+	childNode, childExists := state.get(child)
+	if !parentExists || !childExists || parentNode.child(child) != childNode {
+		return nil
+	}
+	return parentNode.edgeLabels(child)
+}
+
+// setLabels makes the edge parent -> child carry exactly labels, removing it when labels is empty.
+func (state *State[T]) setLabels(parent, child string, labels []string) error {
+	current := state.labelsOf(parent, child)
+	if slices.Equal(current, labels) {
+		return nil
+	}
+	if current != nil {
+		if err := state.disconnect(parent, child); err != nil {
+			return err
+		}
+	}
+	for _, label := range labels {
+		if err := state.connect(parent, child, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
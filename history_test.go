@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestHistoryUndoRedo(t *testing.T) {
+	st := NewState[string](0, 0)
+	h := st.WithHistory()
+	steps := []func() error{
+		func() error { return h.create("a", "1") },
+		func() error { return h.create("b", "2") },
+		func() error { return h.create("c", "3") },
+		func() error { return h.connect("a", "b", "") },
+		func() error { return h.connect("a", "b", "x") },
+		func() error { return h.connect("b", "c", "") },
+		func() error { return h.update("a", "one") },
+		func() error { return h.rename("c", "cc") },
+		func() error { return h.disconnect("a", "b") },
+		func() error { return h.connect("a", "b", "y") },
+		func() error { return h.remove("b") },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	final, _ := st.MarshalJSON()
+	var snapshots [][]byte
+	for range steps {
+		snapshot, _ := st.MarshalJSON()
+		snapshots = append(snapshots, snapshot)
+		if err := h.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := st.Stats(); n != 0 {
+		t.Fatalf("%d nodes left after undoing everything", n)
+	}
+	if err := h.Undo(); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("Undo past the start = %v, want ErrNothingToUndo", err)
+	}
+	for i := range steps {
+		if err := h.Redo(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := st.MarshalJSON(); !bytes.Equal(got, snapshots[len(steps)-1-i]) {
+			t.Fatalf("redo %d gave %s, want %s", i, got, snapshots[len(steps)-1-i])
+		}
+	}
+	if got, _ := st.MarshalJSON(); !bytes.Equal(got, final) {
+		t.Fatalf("after redoing everything %s, want %s", got, final)
+	}
+	if err := h.Redo(); !errors.Is(err, ErrNothingToRedo) {
+		t.Fatalf("Redo past the end = %v, want ErrNothingToRedo", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// defaultPageLimit is the page size GET /nodes/{name}/children uses when no limit is given.
+const defaultPageLimit = 100
+
+// createRequest is the body of POST /nodes.
+type createRequest[T any] struct {
+	Name string `json:"name"`
+	Text T      `json:"text"`
+}
+
+// edgeRequest is the body of POST /edges.
+type edgeRequest struct {
+	Parent string `json:"parent"`
+	Child  string `json:"child"`
+	Label  string `json:"label"`
+}
+
+// writeJSON sends v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends err as a JSON {"error": ...} body.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// statusFor maps an error from a State operation to an HTTP status code.
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, ErrNodeNotFound), errors.Is(err, ErrEdgeNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNodeExists), errors.Is(err, ErrChildLimit), errors.Is(err, ErrNodeLimit),
+		errors.Is(err, ErrWouldCycle), errors.Is(err, ErrVersionConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrSelfLoop), errors.Is(err, ErrInvalidName), errors.Is(err, ErrInvalidArgument):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Handler serves the store over HTTP:
+//
+//	POST   /nodes         {"name", "text"}            create, 409 if the name is taken
+//	GET    /nodes/{name}                              the node as {"name", "text", "children"}, 404 if missing
+//	GET    /nodes/{name}/children?offset=&limit=       a page of sorted child names, 404 if missing
+//	DELETE /nodes/{name}                              remove, 404 if missing
+//	POST   /edges         {"parent", "child", "label"} connect, 404 if either node is missing
+//
+// Other failures map to status codes through statusFor.
+func (state *State[T]) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /nodes", func(w http.ResponseWriter, r *http.Request) {
+		var req createRequest[T]
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := state.create(req.Name, req.Text); err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, req)
+	})
+	mux.HandleFunc("GET /nodes/{name}", func(w http.ResponseWriter, r *http.Request) {
+		node, exists := state.get(r.PathValue("name"))
+		if !exists {
+			writeError(w, http.StatusNotFound, ErrNodeNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, node.record())
+	})
+	mux.HandleFunc("GET /nodes/{name}/children", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if _, exists := state.get(name); !exists {
+			writeError(w, http.StatusNotFound, ErrNodeNotFound)
+			return
+		}
+		offset, limit := 0, defaultPageLimit
+		var err error
+		if v := r.URL.Query().Get("offset"); v != "" {
+			if offset, err = strconv.Atoi(v); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		page, err := state.childrenPage(name, offset, limit)
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	})
+	mux.HandleFunc("DELETE /nodes/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := state.remove(r.PathValue("name")); err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /edges", func(w http.ResponseWriter, r *http.Request) {
+		var req edgeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := state.connect(req.Parent, req.Child, req.Label); err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, req)
+	})
+	return mux
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// do serves one request against h and returns the recorded response.
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	st := &State[string]{}
+	h := st.Handler()
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"POST", "/nodes", `{"name":"A","text":"a"}`, http.StatusCreated},
+		{"POST", "/nodes", `{"name":"B","text":"b"}`, http.StatusCreated},
+		{"POST", "/nodes", `{"name":"A","text":"a"}`, http.StatusConflict},
+		{"POST", "/nodes", `{`, http.StatusBadRequest},
+		{"POST", "/edges", `{"parent":"A","child":"B"}`, http.StatusCreated},
+		{"POST", "/edges", `{"parent":"A","child":"Z"}`, http.StatusNotFound},
+		{"POST", "/edges", `{"parent":"A","child":"A"}`, http.StatusBadRequest},
+		{"GET", "/nodes/Z", ``, http.StatusNotFound},
+		{"DELETE", "/nodes/Z", ``, http.StatusNotFound},
+	} {
+		if rec := do(h, c.method, c.path, c.body); rec.Code != c.code {
+			t.Fatalf("%s %s %s = %d %s, want %d", c.method, c.path, c.body, rec.Code, rec.Body, c.code)
+		}
+	}
+	rec := do(h, "GET", "/nodes/A", "")
+	if want := `{"name":"A","text":"a","children":["B"]}`; rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != want {
+		t.Fatalf("GET /nodes/A = %d %s, want 200 %s", rec.Code, rec.Body, want)
+	}
+	if rec := do(h, "DELETE", "/nodes/B", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /nodes/B = %d, want 204", rec.Code)
+	}
+}
+
+func TestHandlerChildrenPage(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.create("P", "")
+	for i := range 200 {
+		n := fmt.Sprintf("c%03d", i)
+		st.create(n, "")
+		st.connect("P", n, "")
+	}
+	h := st.Handler()
+	rec := do(h, "GET", "/nodes/P/children?offset=190&limit=50", "")
+	var got []string
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if rec.Code != http.StatusOK || len(got) != 10 {
+		t.Fatalf("last page = %d with %d names, want 200 with 10", rec.Code, len(got))
+	}
+	if rec := do(h, "GET", "/nodes/P/children?limit=x", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad limit = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// internTable shares storage between equal string texts. Entries are never evicted,
+// so it only pays off when texts repeat; on mostly unique data it just holds an extra reference.
+type internTable struct {
+	strings sync.Map // map[string]string
+	unique  atomic.Int64
+	total   atomic.Int64
+}
+
+// intern returns the canonical copy of text when T is string, and text unchanged otherwise.
+// It does not count toward internStats' total; call counted once the op has been applied.
+func (state *State[T]) intern(text T) T {
+	s, isString := any(text).(string)
+	if !isString {
+		return text
+	}
+	canonical, loaded := state.interned.strings.LoadOrStore(s, s)
+	if !loaded {
+		state.interned.unique.Add(1)
+	}
+	return canonical.(T)
+}
+
+// counted records text in internStats' total, once the op that stores it has been applied.
+func (state *State[T]) counted(text T) {
+	if _, isString := any(text).(string); isString {
+		state.interned.total.Add(1)
+	}
+}
+
+// internStats reports how many distinct texts the intern table holds
+// and how many texts have been interned in total.
+func (state *State[T]) internStats() (unique int, total int) {
+	return int(state.interned.unique.Load()), int(state.interned.total.Load())
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+func TestInterning(t *testing.T) {
+	st := NewState[string](0, 0)
+	for i := range 1000 {
+		st.create(strconv.Itoa(i), "text"+strconv.Itoa(i%5))
+	}
+	st.update("1", "text0")
+	if unique, total := st.internStats(); unique != 5 || total != 1001 {
+		t.Fatalf("internStats = %d, %d; want 5, 1001", unique, total)
+	}
+	a, _ := st.get("0")
+	b, _ := st.get("5")
+	if unsafe.StringData(a.getText()) != unsafe.StringData(b.getText()) {
+		t.Fatal("equal texts do not share storage")
+	}
+	ints := NewState[int](0, 0)
+	ints.create("x", 3)
+	if unique, total := ints.internStats(); unique != 0 || total != 0 {
+		t.Fatalf("int state internStats = %d, %d; want 0, 0", unique, total)
+	}
+}
+
+// failingWAL refuses every append.
+type failingWAL[T any] struct{}
+
+func (failingWAL[T]) Append(Op[T]) error { return errors.New("disk full") }
+
+func TestInternStatsSkipFailedCreates(t *testing.T) {
+	st := NewStateWithLimit[string](1)
+	st.create("a", "x")
+	st.create("b", "x")
+	st.create("a", "x")
+	if _, total := st.internStats(); total != 1 {
+		t.Fatalf("internStats total = %d after one successful create, want 1", total)
+	}
+	logged := &State[string]{wal: failingWAL[string]{}}
+	if err := logged.create("a", "x"); err == nil {
+		t.Fatal("create succeeded with a failing WAL")
+	}
+	if err := logged.update("a", "y"); err == nil {
+		t.Fatal("update of a missing node succeeded")
+	}
+	if _, total := logged.internStats(); total != 0 {
+		t.Fatalf("internStats total = %d after only failed ops, want 0", total)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// nodeRecord is the serialized form of a single node.
+// Labels only lists edges whose labels are something other than the default "".
+type nodeRecord[T any] struct {
+	Name     string              `json:"name"`
+	Text     T                   `json:"text"`
+	Children []string            `json:"children"`
+	Labels   map[string][]string `json:"labels,omitempty"`
+}
+
+// record snapshots a node's text and live children, with children sorted by name.
+func (node *Node[T]) record() nodeRecord[T] {
+	rec := nodeRecord[T]{Name: node.name, Text: node.getText(), Children: []string{}}
+	for _, child := range node.getValidChildren() {
+		rec.Children = append(rec.Children, child.name)
+		if labels := node.edgeLabels(child.name); !slices.Equal(labels, []string{""}) {
+			if rec.Labels == nil {
+				rec.Labels = make(map[string][]string)
+			}
+			rec.Labels[child.name] = labels
+		}
+	}
+	slices.Sort(rec.Children)
+	return rec
+}
+
+// edgeLabels returns the labels recorded for child, defaulting to the single label "".
+func (rec nodeRecord[T]) edgeLabels(child string) []string {
+	if labels := rec.Labels[child]; len(labels) > 0 {
+		return labels
+	}
+	return []string{""}
+}
+
+// MarshalJSON serializes every live node as a list of {name, text, children} objects sorted by name.
+func (state *State[T]) MarshalJSON() ([]byte, error) {
+	records := []nodeRecord[T]{}
+	state.Range(func(_ string, node *Node[T]) bool {
+		records = append(records, node.record())
+		return true
+	})
+	slices.SortFunc(records, func(a, b nodeRecord[T]) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return json.Marshal(records)
+}
+
+// WriteJSON streams the same bytes MarshalJSON returns to w, one node at a time.
+// Only the sorted names are held in memory, not every record. Nodes removed while it runs are
+// left out, and edges to them can appear in the children of nodes already written.
+func (state *State[T]) WriteJSON(w io.Writer) error {
+	var names []string
+	state.Range(func(name string, _ *Node[T]) bool {
+		names = append(names, name)
+		return true
+	})
+	slices.Sort(names)
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	first := true
+	for _, name := range names {
+		node, exists := state.get(name)
+		if !exists {
+			continue
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		data, err := json.Marshal(node.record())
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// LoadState rebuilds a State from the output of MarshalJSON.
+// All nodes are created before any edge is wired, so record order does not matter.
+func LoadState[T any](data []byte) (*State[T], error) {
+	return LoadStateStream[T](bytes.NewReader(data))
+}
+
+// LoadStateStream is LoadState reading records from r one at a time, so the input never has
+// to fit in memory. Texts go straight into the new State; only the edges are buffered until
+// every node exists, then wired in a second pass.
+func LoadStateStream[T any](r io.Reader) (*State[T], error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, fmt.Errorf("expected a JSON array, got %v", tok)
+	}
+	state := &State[T]{}
+	var edges []nodeRecord[T]
+	for dec.More() {
+		var rec nodeRecord[T]
+		if err := dec.Decode(&rec); err != nil {
+			return nil, err
+		}
+		if err := state.create(rec.Name, rec.Text); err != nil {
+			return nil, fmt.Errorf("node %q: %w", rec.Name, err)
+		}
+		if len(rec.Children) > 0 {
+			var zero T
+			rec.Text = zero
+			edges = append(edges, rec)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON array")
+	}
+	for _, rec := range edges {
+		for _, child := range rec.Children {
+			if _, exists := state.get(child); !exists {
+				return nil, fmt.Errorf("node %q references undefined child %q: %w", rec.Name, child, ErrNodeNotFound)
+			}
+			for _, label := range rec.edgeLabels(child) {
+				if err := state.connect(rec.Name, child, label); err != nil {
+					return nil, fmt.Errorf("node %q child %q: %w", rec.Name, child, err)
+				}
+			}
+		}
+	}
+	return state, nil
+}
+
+// SaveFile writes the MarshalJSON snapshot to path atomically: the data goes to a temporary
+// file in the same directory, is synced, and is then renamed over path.
+func (state *State[T]) SaveFile(path string) error {
+	data, err := state.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile rebuilds a State from a file written by SaveFile.
+func LoadFile[T any](path string) (*State[T], error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadState[T](data)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"B", "A", "C"} {
+		st.create(n, n+"t")
+	}
+	st.connect("A", "C", "")
+	st.connect("A", "B", "")
+	st.connect("B", "C", "")
+	st.remove("C")
+	got, err := st.MarshalJSON()
+	want := `[{"name":"A","text":"At","children":["B"]},{"name":"B","text":"Bt","children":[]}]`
+	if err != nil || string(got) != want {
+		t.Fatalf("MarshalJSON = %s, %v; want %s", got, err, want)
+	}
+}
+
+func TestLoadStateRoundTrip(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"B", "A", "C"} {
+		st.create(n, n+"t")
+	}
+	st.connect("A", "C", "")
+	st.connect("A", "B", "")
+	st.connect("B", "C", "")
+	saved, _ := st.MarshalJSON()
+	loaded, err := LoadState[string](saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := loaded.MarshalJSON(); string(got) != string(saved) {
+		t.Fatalf("reloaded %s, saved %s", got, saved)
+	}
+	if _, err := LoadState[string]([]byte(`[{"name":"A","text":"","children":["X"]}]`)); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("undefined child = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func TestSaveFileRoundTrip(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "x", "b": "y", "c": "z"})
+	st.connect("a", "b", "")
+	st.connect("a", "c", "l")
+	path := filepath.Join(t.TempDir(), "snap.json")
+	if err := st.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFile[string](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := st.MarshalJSON()
+	if got, _ := loaded.MarshalJSON(); string(got) != string(want) {
+		t.Fatalf("loaded %s, saved %s", got, want)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Fatalf("SaveFile left %d files behind, want only the snapshot", len(entries))
+	}
+	if err := st.SaveFile(filepath.Join(t.TempDir(), "missing", "dir", "x.json")); err == nil {
+		t.Fatal("SaveFile into a missing directory succeeded")
+	}
+}
+
+func TestWriteJSONMatchesMarshalJSON(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "x", "b": "y", "c": "z"})
+	st.connect("a", "b", "l")
+	st.connect("a", "c", "")
+	st.create("d", "")
+	st.connect("a", "d", "")
+	st.remove("d")
+	var buf bytes.Buffer
+	if err := st.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	marshalled, _ := st.MarshalJSON()
+	if !bytes.Equal(buf.Bytes(), marshalled) {
+		t.Fatalf("WriteJSON = %s, want %s", buf.Bytes(), marshalled)
+	}
+	loaded, err := LoadState[string](buf.Bytes())
+	if err != nil || loaded.show("a", nil) != st.show("a", nil) {
+		t.Fatalf("reloading WriteJSON output: %v", err)
+	}
+	var empty bytes.Buffer
+	NewState[string](0, 0).WriteJSON(&empty)
+	if empty.String() != "[]" {
+		t.Fatalf("empty WriteJSON = %q, want %q", empty.String(), "[]")
+	}
+}
+
+func TestLoadStateStream(t *testing.T) {
+	st := NewState[string](0, 0)
+	for i := range 2000 {
+		st.create(strconv.Itoa(i), "t"+strconv.Itoa(i))
+		if i > 0 {
+			st.connect(strconv.Itoa(i/2), strconv.Itoa(i), strconv.Itoa(i%3))
+		}
+	}
+	var buf bytes.Buffer
+	st.WriteJSON(&buf)
+	loaded, err := LoadStateStream[string](&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := st.MarshalJSON()
+	if got, _ := loaded.MarshalJSON(); !bytes.Equal(got, want) {
+		t.Fatal("streamed load differs from the original")
+	}
+	for _, bad := range []string{`{}`, `[{"name":"a","text":"","children":["b"]}]`, `[] x`, `[{"name":1}]`} {
+		if _, err := LoadState[string]([]byte(bad)); err == nil {
+			t.Errorf("LoadState(%s) succeeded", bad)
+		}
+		if _, err := LoadStateStream[string](strings.NewReader(bad)); err == nil {
+			t.Errorf("LoadStateStream(%s) succeeded", bad)
+		}
+	}
+}
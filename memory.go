@@ -0,0 +1,41 @@
+package main
+
+import "reflect"
+
+// Rough per-entry costs used by ApproxMemory. Go maps carry bucket and load-factor overhead
+// on top of the key and value, and every edge slot also owns a heap-allocated atomic.Pointer.
+const (
+	mapEntryOverhead  = 48 // string header, value word and a share of the bucket
+	edgeSlotOverhead  = 8  // the *atomic.Pointer's target allocation
+	syncMapEntryBytes = 64 // a sync.Map entry wrapping the node
+)
+
+// ApproxMemory estimates the bytes held by live nodes: each node struct, its name and text,
+// and the entries of its children, parents and label tables. It is a sizing aid only;
+// it ignores allocator rounding and anything a non-string payload points to.
+func (state *State[T]) ApproxMemory() int64 {
+	nodeSize := int64(reflect.TypeFor[Node[T]]().Size())
+	var total int64
+	state.Range(func(name string, node *Node[T]) bool {
+		total += syncMapEntryBytes + nodeSize + int64(len(name))
+		node.lock.RLock()
+		if text, isString := any(node.text).(string); isString {
+			total += int64(len(text))
+		}
+		for childName, labels := range node.labels {
+			total += mapEntryOverhead + int64(len(childName))
+			for label := range labels {
+				total += mapEntryOverhead + int64(len(label))
+			}
+		}
+		for childName := range node.children {
+			total += mapEntryOverhead + edgeSlotOverhead + int64(len(childName))
+		}
+		for parentName := range node.parents {
+			total += mapEntryOverhead + edgeSlotOverhead + int64(len(parentName))
+		}
+		node.lock.RUnlock()
+		return true
+	})
+	return total
+}
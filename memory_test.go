@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApproxMemory(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.create("a", "x")
+	before := st.ApproxMemory()
+	st.create("big", strings.Repeat("x", 100000))
+	if after := st.ApproxMemory(); after < before+100000 {
+		t.Fatalf("ApproxMemory grew from %d to %d, want at least the 100000 text bytes", before, after)
+	}
+	if n := NewState[int](0, 0).ApproxMemory(); n != 0 {
+		t.Fatalf("empty state ApproxMemory = %d, want 0", n)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// opCounters counts successful mutations. They are bumped by publish, so they see exactly
+// what subscribers see, and are always on because an atomic add is cheap.
+type opCounters struct {
+	creates  atomic.Uint64
+	removes  atomic.Uint64
+	connects atomic.Uint64
+}
+
+// count records one successful op of kind.
+func (counters *opCounters) count(kind OpKind) {
+	switch kind {
+	case OpCreate:
+		counters.creates.Add(1)
+	case OpRemove:
+		counters.removes.Add(1)
+	case OpConnect:
+		counters.connects.Add(1)
+	}
+}
+
+// statsCollector reports the node and edge gauges from a single Stats scan per scrape.
+type statsCollector[T any] struct {
+	state *State[T]
+	nodes *prometheus.Desc
+	edges *prometheus.Desc
+}
+
+func (collector *statsCollector[T]) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.nodes
+	ch <- collector.edges
+}
+
+func (collector *statsCollector[T]) Collect(ch chan<- prometheus.Metric) {
+	nodes, edges := collector.state.Stats()
+	ch <- prometheus.MustNewConstMetric(collector.nodes, prometheus.GaugeValue, float64(nodes))
+	ch <- prometheus.MustNewConstMetric(collector.edges, prometheus.GaugeValue, float64(edges))
+}
+
+// RegisterMetrics exposes live node and edge gauges, computed on scrape, and counters of
+// successful create, remove and connect operations. Like MustRegister it panics if
+// registration fails, e.g. when two states are registered with the same registry.
+func (state *State[T]) RegisterMetrics(reg prometheus.Registerer) {
+	counter := func(name, help string, value *atomic.Uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			return float64(value.Load())
+		})
+	}
+	reg.MustRegister(
+		&statsCollector[T]{
+			state: state,
+			nodes: prometheus.NewDesc("par_crud_nodes", "Number of live nodes.", nil, nil),
+			edges: prometheus.NewDesc("par_crud_edges", "Number of live edges.", nil, nil),
+		},
+		counter("par_crud_creates_total", "Successful create operations.", &state.ops.creates),
+		counter("par_crud_removes_total", "Successful remove operations.", &state.ops.removes),
+		counter("par_crud_connects_total", "Successful connect operations.", &state.ops.connects),
+	)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	st := &State[string]{}
+	reg := prometheus.NewPedanticRegistry()
+	st.RegisterMetrics(reg)
+	st.createMany(map[string]string{"A": "", "B": "", "C": ""})
+	st.create("A", "")
+	st.connect("A", "B", "")
+	st.connect("A", "C", "")
+	st.remove("C")
+	if n, err := testutil.GatherAndCount(reg); err != nil || n != 5 {
+		t.Fatalf("gathered %d metrics, %v; want 5", n, err)
+	}
+	want := `
+# HELP par_crud_connects_total Successful connect operations.
+# TYPE par_crud_connects_total counter
+par_crud_connects_total 2
+# HELP par_crud_creates_total Successful create operations.
+# TYPE par_crud_creates_total counter
+par_crud_creates_total 3
+# HELP par_crud_edges Number of live edges.
+# TYPE par_crud_edges gauge
+par_crud_edges 1
+# HELP par_crud_nodes Number of live nodes.
+# TYPE par_crud_nodes gauge
+par_crud_nodes 2
+# HELP par_crud_removes_total Successful remove operations.
+# TYPE par_crud_removes_total counter
+par_crud_removes_total 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// replUsage lists the commands RunREPL understands, with their arguments.
+var replUsage = map[string]string{
+	"create":     "create <name> <text>",
+	"update":     "update <name> <text>",
+	"connect":    "connect <parent> <child> [label]",
+	"disconnect": "disconnect <parent> <child>",
+	"show":       "show <name>",
+	"find":       "find <prefix>",
+	"remove":     "remove <name>",
+	"quit":       "quit",
+}
+
+// RunREPL reads one command per line from in and writes results to out until quit or EOF.
+// Arguments are separated by any run of whitespace; text is the rest of the line after the
+// name, kept as written apart from the whitespace around it.
+func RunREPL(state *State[string], in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+		if cmd == "quit" {
+			return
+		}
+		fmt.Fprintln(out, runCommand(state, cmd, args, afterFields(line, 2)))
+	}
+}
+
+// afterFields returns line without its first n whitespace-separated fields,
+// trimmed of surrounding whitespace.
+func afterFields(line string, n int) string {
+	for range n {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		line = strings.TrimLeftFunc(line, func(r rune) bool { return !unicode.IsSpace(r) })
+	}
+	return strings.TrimSpace(line)
+}
+
+// runCommand executes a single REPL command and returns the line to print.
+// text is the raw remainder of the line after args[0], used by create and update.
+func runCommand(state *State[string], cmd string, args []string, text string) string {
+	usage, known := replUsage[cmd]
+	if !known {
+		return "unknown command: " + cmd
+	}
+	var err error
+	switch {
+	case cmd == "create" && len(args) >= 1:
+		err = state.create(args[0], text)
+	case cmd == "update" && len(args) >= 1:
+		err = state.update(args[0], text)
+	case cmd == "connect" && (len(args) == 2 || len(args) == 3):
+		label := ""
+		if len(args) == 3 {
+			label = args[2]
+		}
+		err = state.connect(args[0], args[1], label)
+	case cmd == "disconnect" && len(args) == 2:
+		err = state.disconnect(args[0], args[1])
+	case cmd == "show" && len(args) == 1:
+		return state.show(args[0], nil)
+	case cmd == "find" && len(args) == 1:
+		return strings.Join(state.findByPrefix(args[0]), " ")
+	case cmd == "remove" && len(args) == 1:
+		_, err = state.remove(args[0])
+	default:
+		return "usage: " + usage
+	}
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return "ok"
+}
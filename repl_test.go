@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunREPL(t *testing.T) {
+	st := &State[string]{}
+	var out strings.Builder
+	RunREPL(st, strings.NewReader("create   A  hello   world\n\ncreate B\nconnect A B\nshow A\nfrob\nremove Z\nshow\nquit\ncreate C x\n"), &out)
+	want := "ok\nok\nok\nNode: \"hello   world\"\nChildren:\n - B\nunknown command: frob\nerror: node does not exist\nusage: show <name>\n"
+	if out.String() != want {
+		t.Fatalf("REPL output =\n%s\nwant\n%s", out.String(), want)
+	}
+	if _, exists := st.get("C"); exists {
+		t.Fatal("commands after quit were run")
+	}
+}
+
+func TestRunREPLKeepsTextSpacing(t *testing.T) {
+	st := &State[string]{}
+	var out strings.Builder
+	RunREPL(st, strings.NewReader("create A a\t b  \nupdate  A   x  y\t\n"), &out)
+	if a, _ := st.get("A"); out.String() != "ok\nok\n" || a.getText() != "x  y" {
+		t.Fatalf("REPL printed %q and stored %q, want the text as typed", out.String(), a.getText())
+	}
+	RunREPL(st, strings.NewReader("create B a\t b\n"), &out)
+	if b, _ := st.get("B"); b.getText() != "a\t b" {
+		t.Fatalf("create stored %q, want %q", b.getText(), "a\t b")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// findByPrefix returns the sorted names of live nodes that start with prefix.
+func (state *State[T]) findByPrefix(prefix string) []string {
+	names := []string{}
+	state.Range(func(name string, node *Node[T]) bool {
+		if strings.HasPrefix(name, prefix) && !node.dead.Load() {
+			names = append(names, name)
+		}
+		return true
+	})
+	slices.Sort(names)
+	return names
+}
+
+// searchText returns the sorted names of live nodes whose text matches pattern.
+// Text is formatted with fmt.Sprint, the same way show renders it by default.
+func (state *State[T]) searchText(pattern string) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	state.Range(func(name string, node *Node[T]) bool {
+		if !node.dead.Load() && re.MatchString(fmt.Sprint(node.getText())) {
+			names = append(names, name)
+		}
+		return true
+	})
+	slices.Sort(names)
+	return names, nil
+}
+
+// textHistogram counts live nodes per text, keyed by the text formatted with fmt.Sprint.
+func (state *State[T]) textHistogram() map[string]int {
+	counts := make(map[string]int)
+	state.Range(func(_ string, node *Node[T]) bool {
+		if !node.dead.Load() {
+			counts[fmt.Sprint(node.getText())]++
+		}
+		return true
+	})
+	return counts
+}
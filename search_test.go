@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestFindByPrefix(t *testing.T) {
+	st := NewState[string](0, 0)
+	for i := 1; i <= 200; i++ {
+		st.create("B"+strconv.Itoa(i), "")
+	}
+	st.remove("B10")
+	got := st.findByPrefix("B1")
+	if len(got) != 1+10+100-1 || slices.Contains(got, "B10") || !slices.IsSorted(got) {
+		t.Fatalf("findByPrefix(B1) returned %d names, want 110 sorted live ones", len(got))
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "apple pie", "b": "banana", "c": "pineapple", "d": "cherry"})
+	if got, err := st.searchText("apple"); err != nil || !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("searchText(apple) = %v, %v; want [a c]", got, err)
+	}
+	if _, err := st.searchText("("); err == nil {
+		t.Fatal("searchText accepted an invalid pattern")
+	}
+}
+
+func TestTextHistogram(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "x", "b": "x", "c": "y", "d": "x", "e": "y"})
+	st.remove("e")
+	if got, want := st.textHistogram(), map[string]int{"x": 3, "y": 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("textHistogram = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// nodeTable maps node names to *Node values. It offers the subset of sync.Map's API that
+// State uses, optionally spreading names over several sync.Maps to cut contention on the
+// map's internals under heavy concurrent create and lookup. The zero value is a single map.
+// It also keeps count of its entries, adjusted only by calls that actually store or delete one.
+type nodeTable struct {
+	single sync.Map
+	shards []sync.Map
+	count  atomic.Int64
+}
+
+// shardCount returns how many maps the table spreads names over.
+func (table *nodeTable) shardCount() int {
+	return max(len(table.shards), 1)
+}
+
+// shard returns the map holding name, chosen by an FNV-1a hash of the name.
+func (table *nodeTable) shard(name string) *sync.Map {
+	if len(table.shards) == 0 {
+		return &table.single
+	}
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
+	}
+	return &table.shards[hash%uint32(len(table.shards))]
+}
+
+// Load is sync.Map.Load on name's shard.
+func (table *nodeTable) Load(name string) (any, bool) {
+	return table.shard(name).Load(name)
+}
+
+// LoadOrStore is sync.Map.LoadOrStore on name's shard.
+func (table *nodeTable) LoadOrStore(name string, value any) (any, bool) {
+	actual, loaded := table.shard(name).LoadOrStore(name, value)
+	if !loaded {
+		table.count.Add(1)
+	}
+	return actual, loaded
+}
+
+// loadOrStoreLimited is LoadOrStore that refuses to store once the table holds limit entries,
+// reporting full instead. A limit of zero or less means no limit. The slot is reserved before
+// storing, so concurrent callers can never push the count past limit.
+func (table *nodeTable) loadOrStoreLimited(name string, value any, limit int64) (actual any, loaded bool, full bool) {
+	if limit <= 0 {
+		actual, loaded = table.LoadOrStore(name, value)
+		return actual, loaded, false
+	}
+	for {
+		count := table.count.Load()
+		if count >= limit {
+			return nil, false, true
+		}
+		if table.count.CompareAndSwap(count, count+1) {
+			break
+		}
+	}
+	actual, loaded = table.shard(name).LoadOrStore(name, value)
+	if loaded {
+		table.count.Add(-1)
+	}
+	return actual, loaded, false
+}
+
+// full reports whether loadOrStoreLimited would refuse a new entry under limit right now.
+func (table *nodeTable) full(limit int64) bool {
+	return limit > 0 && table.count.Load() >= limit
+}
+
+// LoadAndDelete is sync.Map.LoadAndDelete on name's shard.
+func (table *nodeTable) LoadAndDelete(name string) (any, bool) {
+	value, loaded := table.shard(name).LoadAndDelete(name)
+	if loaded {
+		table.count.Add(-1)
+	}
+	return value, loaded
+}
+
+// CompareAndDelete is sync.Map.CompareAndDelete on name's shard.
+func (table *nodeTable) CompareAndDelete(name string, old any) bool {
+	deleted := table.shard(name).CompareAndDelete(name, old)
+	if deleted {
+		table.count.Add(-1)
+	}
+	return deleted
+}
+
+// Range is sync.Map.Range across every shard in turn, stopping as soon as f returns false.
+func (table *nodeTable) Range(f func(key, value any) bool) {
+	if len(table.shards) == 0 {
+		table.single.Range(f)
+		return
+	}
+	for i := range table.shards {
+		stopped := false
+		table.shards[i].Range(func(key, value any) bool {
+			if !f(key, value) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if stopped {
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShardedState(t *testing.T) {
+	st := NewShardedState[string](0, 0, 8)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				st.create(fmt.Sprint(g, "-", i), "")
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _ := st.Stats(); n != 1600 {
+		t.Fatalf("%d nodes, want 1600", n)
+	}
+	st.connect("0-1", "3-4", "")
+	if got, _ := st.childrenOf("0-1"); len(got) != 1 {
+		t.Fatalf("childrenOf(0-1) = %v, want one child", got)
+	}
+	visited := 0
+	st.Range(func(string, *Node[string]) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Fatalf("Range visited %d nodes after being told to stop at 10", visited)
+	}
+	clone, err := st.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := clone.nodes.shardCount(); n != 8 {
+		t.Fatalf("clone has %d shards, want 8", n)
+	}
+	st.rename("0-1", "renamed")
+	st.remove("3-4")
+	if _, exists := st.get("renamed"); !exists {
+		t.Fatal("renamed node not found")
+	}
+}
+
+// benchCreateGet creates a node and looks up an earlier one on every iteration, from
+// parallel goroutines.
+func benchCreateGet(b *testing.B, st *State[string]) {
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := n.Add(1)
+			st.create(strconv.FormatInt(i, 10), "")
+			st.get(strconv.FormatInt(i/2, 10))
+		}
+	})
+}
+
+func BenchmarkCreateGetSingle(b *testing.B)  { benchCreateGet(b, NewState[string](0, 0)) }
+func BenchmarkCreateGetSharded(b *testing.B) { benchCreateGet(b, NewShardedState[string](0, 0, 16)) }
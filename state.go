@@ -1,207 +1,1519 @@
 package main
 
 import (
+	"cmp"
 	"errors"
+	"flag"
 	"fmt"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-const cleanupFreq int64 = 100
+// defaultCleanupFreq is used whenever a State or Node has no cleanupFreq configured.
+const defaultCleanupFreq int64 = 100
 
-type Node struct {
-	dead           atomic.Bool
-	lock           sync.RWMutex
-	
-	text           string
-	name           string
-	children       map[string]*atomic.Pointer[Node]
-	
-	cleanupCounter atomic.Int64 //used so we periodically clear the internal table (otherwise we leak memory)
+// nextNodeID hands out Node ids. Names can repeat once a node is removed, ids never do.
+var nextNodeID atomic.Uint64
+
+// Node is a vertex of the graph. T is the payload type stored in text; name is always the key.
+type Node[T any] struct {
+	dead atomic.Bool
+	lock sync.RWMutex
+
+	text     T
+	version  atomic.Uint64 //bumped on every text change, written under the write lock
+	created  time.Time     //set once by NewNode
+	modified atomic.Int64  //unix nanos of the last text change, starts out equal to created
+	name     string
+	id       uint64 //unique per process, orders locks in lockNodesOrdered
+	children map[string]*atomic.Pointer[Node[T]]
+	labels   map[string]map[string]bool          //child name -> labels on that edge, "" is the default label
+	parents  map[string]*atomic.Pointer[Node[T]] //back-references, maintained symmetrically with children
+
+	cleanupCounter       atomic.Int64  //used so we periodically clear the internal table (otherwise we leak memory)
+	parentCleanupCounter atomic.Int64  //same as cleanupCounter but for the parents table
+	cleanupFreq          int64         //copied from the owning State, zero means defaultCleanupFreq
+	maxChildren          int           //copied from the owning State, zero means unlimited
+	cleanupPolicy        CleanupPolicy //copied from the owning State, nil means the default heuristic
+	expires              time.Time     //zero for nodes without a TTL, set before the node is published
 }
 
 // NewNode creates a new Node.
-func NewNode(name, text string) *Node {
-	return &Node{
+func NewNode[T any](name string, text T) *Node[T] {
+	node := &Node[T]{
+		id:       nextNodeID.Add(1),
 		name:     name,
 		text:     text,
-		children: make(map[string]*atomic.Pointer[Node]),
+		created:  time.Now(),
+		children: make(map[string]*atomic.Pointer[Node[T]]),
+		labels:   make(map[string]map[string]bool),
+		parents:  make(map[string]*atomic.Pointer[Node[T]]),
+	}
+	node.modified.Store(node.created.UnixNano())
+	return node
+}
+
+// Created returns when the node was constructed.
+func (node *Node[T]) Created() time.Time {
+	return node.created
+}
+
+// Modified returns when the node's text last changed, or its creation time if it never has.
+func (node *Node[T]) Modified() time.Time {
+	return time.Unix(0, node.modified.Load())
+}
+
+// expired reports whether the node had a TTL that has now passed.
+func (node *Node[T]) expired() bool {
+	return !node.expires.IsZero() && !time.Now().Before(node.expires)
+}
+
+// CleanupPolicy decides, each time a dead pointer is reset, whether the table should be swept.
+// counter is the number of nil slots now in the table and tableLen its size, nil slots included.
+// It is called concurrently by readers holding the read lock, so it must be safe for that.
+type CleanupPolicy func(counter int64, tableLen int) bool
+
+// shouldCleanup applies the node's CleanupPolicy. Without one, cleanup fires on every
+// freq-th nil slot once at least half of the table is garbage; exactly one caller sees
+// each multiple of freq, so that default never triggers the same sweep twice.
+func (node *Node[T]) shouldCleanup(counter int64, tableLen int) bool {
+	if node.cleanupPolicy != nil {
+		return node.cleanupPolicy(counter, tableLen)
 	}
+	return counter%node.freq() == 0 && 2*counter >= int64(tableLen)
 }
 
-// addChild stores a child in the children map via an atomic pointer.
-func (node *Node) addChild(child *Node) {
+// freq returns the node's effective cleanup frequency.
+func (node *Node[T]) freq() int64 {
+	if node.cleanupFreq <= 0 {
+		return defaultCleanupFreq
+	}
+	return node.cleanupFreq
+}
+
+// addChild stores a child in the children map via an atomic pointer, tagging the edge with label.
+// Re-adding a child under a name already in the map reuses that entry's pointer.
+func (node *Node[T]) addChild(child *Node[T], label string) error {
 	node.lock.Lock()
 	defer node.lock.Unlock()
-	var ptr atomic.Pointer[Node]
+	_, err := node.putChild(child, label)
+	return err
+}
+
+// addChildOnce is addChild but reports whether anything changed.
+// It returns false, changing nothing, if the edge to this exact child already carries label.
+func (node *Node[T]) addChildOnce(child *Node[T], label string) (bool, error) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return node.putChild(child, label)
+}
+
+// addChildren is addChild for many children under a single acquisition of the write lock.
+// before runs, still under the lock, for each child that fits, right before it is added;
+// if it fails nothing more is added and its error is returned. It returns the children that
+// were added and those turned away because the node is full.
+func (node *Node[T]) addChildren(children []*Node[T], label string, before func(child *Node[T]) error) (added, rejected []*Node[T], err error) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	for _, child := range children {
+		if !node.fitsLocked(child) {
+			rejected = append(rejected, child)
+			continue
+		}
+		if err := before(child); err != nil {
+			return added, rejected, err
+		}
+		node.putChild(child, label)
+		added = append(added, child)
+	}
+	return added, rejected, nil
+}
+
+// liveChildrenLocked counts children that are not dead. The caller must hold the lock.
+func (node *Node[T]) liveChildrenLocked() int {
+	count := 0
+	for _, ptr := range node.children {
+		if target := ptr.Load(); target != nil && !target.dead.Load() {
+			count++
+		}
+	}
+	return count
+}
+
+// fits reports whether putChild would accept child rather than fail with ErrChildLimit.
+func (node *Node[T]) fits(child *Node[T]) bool {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	return node.fitsLocked(child)
+}
+
+// fitsLocked implements fits: the edge to this exact child exists already, or the node is
+// below maxChildren. The caller must hold the lock.
+func (node *Node[T]) fitsLocked(child *Node[T]) bool {
+	if ptr, exists := node.children[child.name]; exists && ptr.Load() == child {
+		return true
+	}
+	return node.maxChildren <= 0 || node.liveChildrenLocked() < node.maxChildren
+}
+
+// putChild adds label to the edge to child. If the slot for child.name held anything other than
+// this exact child, the old edge and its labels are replaced, keeping the slot's pointer.
+// It returns false if the edge already had label, and an error if a new edge would take
+// the node past maxChildren. The caller must hold the write lock.
+func (node *Node[T]) putChild(child *Node[T], label string) (bool, error) {
+	if node.children == nil {
+		node.children = make(map[string]*atomic.Pointer[Node[T]])
+	}
+	if node.labels == nil {
+		node.labels = make(map[string]map[string]bool)
+	}
+	ptr, exists := node.children[child.name]
+	if exists && ptr.Load() == child {
+		if node.labels[child.name][label] {
+			return false, nil
+		}
+		node.labels[child.name][label] = true
+		return true, nil
+	}
+	if !node.fitsLocked(child) {
+		return false, ErrChildLimit
+	}
+	reclaimSlot(node.children, child.name, &node.cleanupCounter)
+	if !exists {
+		ptr = new(atomic.Pointer[Node[T]])
+		node.children[child.name] = ptr
+	}
 	ptr.Store(child)
-	node.children[child.name] = &ptr
+	node.labels[child.name] = map[string]bool{label: true}
+	return true, nil
+}
+
+// edgeLabels returns the sorted labels on the edge to childName.
+func (node *Node[T]) edgeLabels(childName string) []string {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	return slices.Sorted(maps.Keys(node.labels[childName]))
+}
+
+// addParent stores a parent in the parents map via an atomic pointer.
+// Re-adding an existing parent reuses its pointer, so duplicate edges leave a single entry.
+func (node *Node[T]) addParent(parent *Node[T]) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.putParent(parent)
+}
+
+// putParent implements addParent. The caller must hold the write lock.
+func (node *Node[T]) putParent(parent *Node[T]) {
+	reclaimSlot(node.parents, parent.name, &node.parentCleanupCounter)
+	if ptr, exists := node.parents[parent.name]; exists {
+		ptr.Store(parent)
+		return
+	}
+	var ptr atomic.Pointer[Node[T]]
+	ptr.Store(parent)
+	node.parents[parent.name] = &ptr
+}
+
+// removeParent deletes the back-reference to parent, mirroring removeChild.
+func (node *Node[T]) removeParent(parent *Node[T]) bool {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return node.deleteParent(parent)
+}
+
+// deleteParent implements removeParent. The caller must hold the write lock.
+func (node *Node[T]) deleteParent(parent *Node[T]) bool {
+	ptr, exists := node.parents[parent.name]
+	if !exists || ptr.Load() != parent {
+		return false
+	}
+	delete(node.parents, parent.name)
+	return true
+}
+
+// removeChild deletes the edge to child, with all of its labels, from the children map.
+// The entry is dropped outright rather than nilled, so it never counts towards cleanup.
+// It returns false if there is no live edge to this exact child.
+func (node *Node[T]) removeChild(child *Node[T]) bool {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return node.deleteChild(child)
+}
+
+// deleteChild implements removeChild. The caller must hold the write lock.
+func (node *Node[T]) deleteChild(child *Node[T]) bool {
+	ptr, exists := node.children[child.name]
+	if !exists || ptr.Load() != child {
+		return false
+	}
+	delete(node.children, child.name)
+	delete(node.labels, child.name)
+	return true
+}
+
+// link wires parent -> child under label in both the children and the parents tables.
+func link[T any](parent, child *Node[T], label string) error {
+	if err := parent.addChild(child, label); err != nil {
+		return err
+	}
+	child.addParent(parent)
+	return nil
+}
+
+// unlink removes the parent -> child edge from both tables, reporting whether it existed.
+func unlink[T any](parent, child *Node[T]) bool {
+	if !parent.removeChild(child) {
+		return false
+	}
+	child.removeParent(parent)
+	return true
+}
+
+// reclaimSlot is called before overwriting table[name]. If that slot was already reset to nil
+// it stops being garbage, so it is taken back out of counter. The caller must hold the write lock.
+func reclaimSlot[T any](table map[string]*atomic.Pointer[Node[T]], name string, counter *atomic.Int64) {
+	if ptr, exists := table[name]; exists && ptr.Load() == nil {
+		counter.Add(-1)
+	}
 }
 
 // getAndResetDead checks if the given pointer's Node is dead.
 // If dead, it atomically resets the pointer to nil, increments the cleanup counter,
 // and returns (nil, true) if the cleanup condition is met.
-func (node *Node) getAndResetDead(ptr *atomic.Pointer[Node]) (*Node, bool) {
-	child := ptr.Load()
-	if child != nil && child.dead.Load() {
-		if ptr.CompareAndSwap(child, nil) {
-			newCount := node.cleanupCounter.Add(1)
-			if newCount%cleanupFreq == 0 && newCount > 2*int64(len(node.children)) {
+// The caller must hold at least the read lock; that keeps the counter equal to the number
+// of nil slots in the table, since sweeps and overwrites only happen under the write lock.
+func (node *Node[T]) getAndResetDead(ptr *atomic.Pointer[Node[T]]) (*Node[T], bool) {
+	return resetDead(ptr, &node.cleanupCounter, len(node.children), node.shouldCleanup)
+}
+
+// getAndResetDeadParent is getAndResetDead for pointers in the parents map.
+func (node *Node[T]) getAndResetDeadParent(ptr *atomic.Pointer[Node[T]]) (*Node[T], bool) {
+	return resetDead(ptr, &node.parentCleanupCounter, len(node.parents), node.shouldCleanup)
+}
+
+// resetDead implements getAndResetDead against a specific table's counter and size.
+// Only the CAS winner counts a slot and asks policy whether the new count calls for cleanup.
+func resetDead[T any](ptr *atomic.Pointer[Node[T]], counter *atomic.Int64, tableLen int, policy CleanupPolicy) (*Node[T], bool) {
+	target := ptr.Load()
+	if target != nil && target.dead.Load() {
+		if ptr.CompareAndSwap(target, nil) {
+			if policy(counter.Add(1), tableLen) {
 				return nil, true
 			}
 		}
 		return nil, false
 	}
-	return child, false
+	return target, false
 }
 
-// conditionalCleanup calls cleanup if shouldCleanup is true.
-func (node *Node) conditionalCleanup(shouldCleanup bool) {
+// conditionalCleanup calls cleanup if shouldCleanup is true. The caller must not hold the lock.
+func (node *Node[T]) conditionalCleanup(shouldCleanup bool) {
 	if shouldCleanup {
 		node.cleanup()
 	}
 }
 
-// cleanup performs a full cleanup of the children map under a write lock.
+// cleanup performs a full cleanup of the children and parents maps under a write lock.
 // WARNING: This method acquires a write lock. Do not call it while holding a read lock.
-func (node *Node) cleanup() {
+func (node *Node[T]) cleanup() {
 	node.lock.Lock()
 	defer node.lock.Unlock()
-	currentCount := node.cleanupCounter.Load()
-	for key, ptr := range node.children {
-		if ptr.Load() == nil {
-			delete(node.children, key)
+	sweepTable(node.children, &node.cleanupCounter)
+	sweepTable(node.parents, &node.parentCleanupCounter)
+	for name := range node.labels {
+		if _, exists := node.children[name]; !exists {
+			delete(node.labels, name)
+		}
+	}
+}
+
+// sweepTable deletes nil or dead entries from table and resets its counter. The caller must hold the write lock,
+// which excludes every getAndResetDead, so no nil slot can appear between the sweep and the reset.
+func sweepTable[T any](table map[string]*atomic.Pointer[Node[T]], counter *atomic.Int64) {
+	for key, ptr := range table {
+		if target := ptr.Load(); target == nil || target.dead.Load() {
+			delete(table, key)
 		}
 	}
-	delta := currentCount - (currentCount % cleanupFreq)
-	node.cleanupCounter.Add(-delta)
+	counter.Store(0)
+}
+
+// getText returns the node's text under the read lock.
+func (node *Node[T]) getText() T {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	return node.text
+}
+
+// setText replaces the node's text under the write lock and bumps its version.
+func (node *Node[T]) setText(text T) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.replaceText(text)
+}
+
+// replaceText sets the text, bumps the version and stamps the modified time,
+// returning the new version. The caller must hold the write lock.
+func (node *Node[T]) replaceText(text T) uint64 {
+	node.text = text
+	node.modified.Store(time.Now().UnixNano())
+	return node.version.Add(1)
 }
 
 // child retrieves a child by name using getAndResetDead.
-func (node *Node) child(childName string) *Node {
+func (node *Node[T]) child(childName string) *Node[T] {
 	node.lock.RLock()
 	ptr, exists := node.children[childName]
-	node.lock.RUnlock()
 	if !exists {
+		node.lock.RUnlock()
 		return nil
 	}
 	child, cleanupNeeded := node.getAndResetDead(ptr)
-	// Call conditionalCleanup after releasing the lock.
+	node.lock.RUnlock()
+	// Clean up only after releasing the lock, see scanChildren.
 	node.conditionalCleanup(cleanupNeeded)
 	return child
 }
 
-// getValidChildren iterates over the children map in a single loop,
-// calling getAndResetDead for each pointer and deleting entries that become nil.
-// It then conditionally cleans up.
-// The deferred anonymous function ensures that conditionalCleanup is called after the lock is released.
-func (node *Node) getValidChildren() []*Node {
-	var cleanupNeeded bool = false
-	// Call conditionalCleanup once after we release the lock
-	defer func() {
-		node.conditionalCleanup(cleanupNeeded)
-	}()
+// Every read that resets dead pointers follows the same two steps: scan a table while holding
+// the read lock, then, with the lock released, run cleanup if the scan made it due. cleanup takes
+// the write lock and sync.RWMutex is not reentrant, so sweeping while still holding the read lock
+// would deadlock. The read* helpers below do the first step and return whether cleanup is due;
+// their callers do the second.
+
+// scanChildren calls visit for every live child, resetting dead pointers along the way,
+// and then cleans up if that is due. visit runs under the read lock and must not lock node.
+func (node *Node[T]) scanChildren(visit func(name string, child *Node[T])) {
+	cleanupNeeded := node.readChildren(visit)
+	node.conditionalCleanup(cleanupNeeded)
+}
 
+// readChildren is the half of scanChildren that holds the read lock.
+func (node *Node[T]) readChildren(visit func(name string, child *Node[T])) (cleanupNeeded bool) {
 	node.lock.RLock()
 	defer node.lock.RUnlock()
-	var valid []*Node
-	for _, ptr := range node.children {
+	for name, ptr := range node.children {
 		child, needCleanup := node.getAndResetDead(ptr)
-		if needCleanup {
-			cleanupNeeded = true
+		cleanupNeeded = cleanupNeeded || needCleanup
+		if child != nil {
+			visit(name, child)
 		}
-		if child !=nil {
+	}
+	return cleanupNeeded
+}
+
+// getValidChildren returns the node's live children, resetting dead pointers as it goes.
+func (node *Node[T]) getValidChildren() []*Node[T] {
+	var valid []*Node[T]
+	node.scanChildren(func(_ string, child *Node[T]) {
+		valid = append(valid, child)
+	})
+	return valid
+}
+
+// childrenMap is getValidChildren keyed by child name.
+func (node *Node[T]) childrenMap() map[string]*Node[T] {
+	children := make(map[string]*Node[T])
+	node.scanChildren(func(name string, child *Node[T]) {
+		children[name] = child
+	})
+	return children
+}
+
+// liveChildCount is len(getValidChildren()) without building the slice.
+// It prunes dead pointers the same way.
+func (node *Node[T]) liveChildCount() int {
+	count := 0
+	node.scanChildren(func(string, *Node[T]) {
+		count++
+	})
+	return count
+}
+
+// childrenByLabel is getValidChildren restricted to edges that carry label.
+func (node *Node[T]) childrenByLabel(label string) []*Node[T] {
+	var valid []*Node[T]
+	node.scanChildren(func(name string, child *Node[T]) {
+		if node.labels[name][label] {
 			valid = append(valid, child)
 		}
+	})
+	return valid
+}
+
+// childrenByNames is child for many names under a single read lock.
+// Only names with a live child appear in the result.
+func (node *Node[T]) childrenByNames(names []string) map[string]*Node[T] {
+	found, cleanupNeeded := node.readChildrenByNames(names)
+	node.conditionalCleanup(cleanupNeeded)
+	return found
+}
+
+// readChildrenByNames is the half of childrenByNames that holds the read lock.
+func (node *Node[T]) readChildrenByNames(names []string) (found map[string]*Node[T], cleanupNeeded bool) {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	found = make(map[string]*Node[T], len(names))
+	for _, name := range names {
+		ptr, exists := node.children[name]
+		if !exists {
+			continue
+		}
+		child, needCleanup := node.getAndResetDead(ptr)
+		cleanupNeeded = cleanupNeeded || needCleanup
+		if child != nil {
+			found[name] = child
+		}
 	}
+	return found, cleanupNeeded
+}
+
+// CleanupCounter returns the number of child slots reset to nil since the last sweep,
+// the count that drives cleanup. It is a single atomic load and changes nothing.
+func (node *Node[T]) CleanupCounter() int64 {
+	return node.cleanupCounter.Load()
+}
+
+// pendingDead counts child pointers whose target is dead but has not been reset yet.
+// Unlike getValidChildren it only reads, so looking does not change the answer.
+// Slots already reset to nil are tracked by cleanupCounter instead.
+func (node *Node[T]) pendingDead() int {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	count := 0
+	for _, ptr := range node.children {
+		if target := ptr.Load(); target != nil && target.dead.Load() {
+			count++
+		}
+	}
+	return count
+}
+
+// peekChildren is getValidChildren that only reads: dead pointers are skipped rather than
+// reset, so it never touches the table, its counter or cleanup.
+func (node *Node[T]) peekChildren() []*Node[T] {
+	return node.peek(node.children)
+}
+
+// peekParents is peekChildren for the parents map.
+func (node *Node[T]) peekParents() []*Node[T] {
+	return node.peek(node.parents)
+}
+
+// peek implements peekChildren and peekParents against one of the node's tables.
+func (node *Node[T]) peek(table map[string]*atomic.Pointer[Node[T]]) []*Node[T] {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	var live []*Node[T]
+	for _, ptr := range table {
+		if target := ptr.Load(); target != nil && !target.dead.Load() {
+			live = append(live, target)
+		}
+	}
+	return live
+}
+
+// getParents mirrors getValidChildren for the parents map.
+func (node *Node[T]) getParents() []*Node[T] {
+	valid, cleanupNeeded := node.readParents()
+	node.conditionalCleanup(cleanupNeeded)
 	return valid
 }
 
-//
+// readParents is the half of getParents that holds the read lock.
+func (node *Node[T]) readParents() (valid []*Node[T], cleanupNeeded bool) {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	for _, ptr := range node.parents {
+		parent, needCleanup := node.getAndResetDeadParent(ptr)
+		cleanupNeeded = cleanupNeeded || needCleanup
+		if parent != nil {
+			valid = append(valid, parent)
+		}
+	}
+	return valid, cleanupNeeded
+}
+
 // State holds all live nodes. A node is marked dead only after removal from State.
-//
-type State struct {
-	nodes sync.Map // map[string]*Node
+type State[T any] struct {
+	nodes         nodeTable // map[string]*Node[T], see NewShardedState
+	tombstones    sync.Map  // map[string]*tombstone[T], see softRemove
+	subs          subscribers
+	ops           opCounters
+	interned      internTable   // string texts passed to create and update, see intern
+	Hooks         Hooks         // optional, set before the State is shared between goroutines
+	CleanupPolicy CleanupPolicy // optional sweep trigger, applies to nodes created after it is set
+	wal           WAL[T]        // optional, every mutation is appended here before it is applied
+	walLock       sync.Mutex    // orders logged mutations, see lockWAL
+	cleanupFreq   int64         // handed to every node this State creates, zero means defaultCleanupFreq
+	maxChildren   int           // handed to every node this State creates, zero means unlimited
+	maxNodes      int           // create fails once this many nodes are stored, zero means unlimited
+
+	nameValidator atomic.Pointer[func(name string) error] // see SetNameValidator
 }
 
-func (state *State) create(name, text string) error {
-	node := NewNode(name, text)
-	if _, loaded := state.nodes.LoadOrStore(name, node); loaded {
-		return errors.New("node already exists")
+// NewState creates a State whose nodes clean up after every cleanupFreq dead pointers
+// and accept at most maxChildren live children each.
+// A cleanupFreq of zero uses defaultCleanupFreq and a maxChildren of zero means no limit.
+func NewState[T any](cleanupFreq int64, maxChildren int) *State[T] {
+	return NewShardedState[T](cleanupFreq, maxChildren, 1)
+}
+
+// NewStateWithLimit is NewState with default settings whose create fails once max nodes are
+// stored. Removing a node frees its slot. Expired nodes hold theirs until they are retired,
+// which any get, Range or CleanupAll does.
+func NewStateWithLimit[T any](max int) *State[T] {
+	state := NewState[T](0, 0)
+	state.maxNodes = max
+	return state
+}
+
+// NewShardedState is NewState with node names hashed across shards internal maps.
+// The API is unchanged; sharding only spreads contention on the map itself.
+// A shards count below two gives the single map NewState uses.
+func NewShardedState[T any](cleanupFreq int64, maxChildren int, shards int) *State[T] {
+	if cleanupFreq <= 0 {
+		cleanupFreq = defaultCleanupFreq
+	}
+	state := &State[T]{cleanupFreq: cleanupFreq, maxChildren: maxChildren}
+	if shards > 1 {
+		state.nodes.shards = make([]sync.Map, shards)
+	}
+	return state
+}
+
+// SetNameValidator makes create and rename reject any name for which fn returns an error.
+// A nil fn, the default, accepts every name. Names already stored are not rechecked, not even
+// when Clone, restore or Undo store them again.
+func (state *State[T]) SetNameValidator(fn func(name string) error) {
+	if fn == nil {
+		state.nameValidator.Store(nil)
+		return
+	}
+	state.nameValidator.Store(&fn)
+}
+
+// validateName runs the validator set by SetNameValidator, if any, wrapping its error in ErrInvalidName.
+func (state *State[T]) validateName(name string) error {
+	if fn := state.nameValidator.Load(); fn != nil {
+		if err := (*fn)(name); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidName, err)
+		}
 	}
 	return nil
 }
 
-func (state *State) remove(name string) error {
+// newNode creates a node configured with this state's settings.
+func (state *State[T]) newNode(name string, text T) *Node[T] {
+	node := NewNode(name, text)
+	node.cleanupFreq = state.cleanupFreq
+	node.maxChildren = state.maxChildren
+	node.cleanupPolicy = state.CleanupPolicy
+	return node
+}
+
+func (state *State[T]) create(name string, text T) error {
+	return state.createExpiring(name, text, time.Time{})
+}
+
+// createWithTTL creates a node that expires after ttl. Expired nodes are retired lazily,
+// by get, Range or CleanupAll (and so StartCleaner), through the same path as remove,
+// so edges pointing at them are reclaimed by the usual dead-pointer cleanup.
+func (state *State[T]) createWithTTL(name string, text T, ttl time.Duration) error {
+	return state.createExpiring(name, text, time.Now().Add(ttl))
+}
+
+// createExpiring creates a node that expires at expires, or never when it is zero.
+func (state *State[T]) createExpiring(name string, text T, expires time.Time) error {
+	if err := state.validateName(name); err != nil {
+		return err
+	}
+	return state.createTrusted(name, text, expires)
+}
+
+// createTrusted is createExpiring without the name validator, for names that were stored
+// before it was set: copies, restored tombstones and undone removes.
+func (state *State[T]) createTrusted(name string, text T, expires time.Time) error {
+	defer state.lockWAL()()
+	// A create refused for the node limit must not reach the log; lockWAL keeps the store
+	// from filling up between this check and the one below. get retires an expired node
+	// under name first, so it does not count toward the limit it is about to free.
+	state.get(name)
+	if state.nodes.full(int64(state.maxNodes)) {
+		return state.fullError(name)
+	}
+	text = state.intern(text)
+	op := Op[T]{Kind: OpCreate, Name: name, Text: text}
+	if !expires.IsZero() {
+		op.Expires = expires.UnixNano()
+	}
+	if err := state.log(op); err != nil {
+		return err
+	}
+	node := state.newNode(name, text)
+	node.expires = expires
+	for {
+		rawValue, loaded, full := state.nodes.loadOrStoreLimited(name, node, int64(state.maxNodes))
+		if full {
+			return state.fullError(name)
+		}
+		if !loaded {
+			state.counted(text)
+			state.publish(Event{Kind: OpCreate, Name: name})
+			return nil
+		}
+		existing := rawValue.(*Node[T])
+		if !existing.expired() {
+			return ErrNodeExists
+		}
+		state.expire(name, existing)
+	}
+}
+
+// fullError is the error for creating name in a store at its node limit: ErrNodeExists when
+// name is taken anyway, ErrNodeLimit otherwise.
+func (state *State[T]) fullError(name string) error {
+	if _, exists := state.get(name); exists {
+		return ErrNodeExists
+	}
+	return ErrNodeLimit
+}
+
+// expire retires an expired node exactly like remove, if it is still stored under name.
+func (state *State[T]) expire(name string, node *Node[T]) {
+	if state.nodes.CompareAndDelete(name, node) {
+		node.dead.Store(true)
+		state.publish(Event{Kind: OpRemove, Name: name})
+	}
+}
+
+// createMany creates every name -> text pair, in name order.
+// It does not roll back: names that were created stay created even when others collide.
+// created lists the successes and err joins one error per failed name.
+func (state *State[T]) createMany(entries map[string]T) (created []string, err error) {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		if createErr := state.create(name, entries[name]); createErr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, createErr))
+			continue
+		}
+		created = append(created, name)
+	}
+	return created, errors.Join(errs...)
+}
+
+// remove deletes a node from State and marks it dead, returning the removed node.
+func (state *State[T]) remove(name string) (*Node[T], error) {
+	defer state.lockWAL()()
+	if err := state.log(Op[T]{Kind: OpRemove, Name: name}); err != nil {
+		return nil, err
+	}
 	rawValue, loaded := state.nodes.LoadAndDelete(name)
 	if !loaded {
-		return errors.New("node does not exist")
+		return nil, ErrNodeNotFound
 	}
-	removedNode := rawValue.(*Node)
+	removedNode := rawValue.(*Node[T])
 	removedNode.dead.Store(true)
+	state.publish(Event{Kind: OpRemove, Name: name})
+	return removedNode, nil
+}
+
+// removeMany removes each name in order, marking it dead, and partitions the names into
+// those it removed and those it could not, which normally means they did not exist.
+func (state *State[T]) removeMany(names []string) (removed []string, missing []string) {
+	for _, name := range names {
+		if _, err := state.remove(name); err != nil {
+			missing = append(missing, name)
+			continue
+		}
+		removed = append(removed, name)
+	}
+	return removed, missing
+}
+
+// update replaces a node's text, bumping its version.
+func (state *State[T]) update(name string, text T) error {
+	defer state.lockWAL()()
+	node, exists := state.get(name)
+	if !exists {
+		return ErrNodeNotFound
+	}
+	text = state.intern(text)
+	if err := state.log(Op[T]{Kind: OpUpdate, Name: name, Text: text}); err != nil {
+		return err
+	}
+	node.setText(text)
+	state.counted(text)
+	state.publish(Event{Kind: OpUpdate, Name: name})
 	return nil
 }
 
-func (state *State) get(name string) (*Node, bool) {
+// updateIfVersion is update that only applies when the node is still at version expected.
+// It returns the new version, or the current one together with a conflict error.
+func (state *State[T]) updateIfVersion(name string, text T, expected uint64) (uint64, error) {
+	defer state.lockWAL()()
+	node, exists := state.get(name)
+	if !exists {
+		return 0, ErrNodeNotFound
+	}
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if current := node.version.Load(); current != expected {
+		return current, fmt.Errorf("%w: expected %d, have %d", ErrVersionConflict, expected, current)
+	}
+	text = state.intern(text)
+	if err := state.log(Op[T]{Kind: OpUpdate, Name: name, Text: text}); err != nil {
+		return expected, err
+	}
+	version := node.replaceText(text)
+	state.counted(text)
+	state.publish(Event{Kind: OpUpdate, Name: name})
+	return version, nil
+}
+
+// upsert creates name with text, or if it already exists replaces its text with
+// merge(old, text), computed under the node's write lock so concurrent upserts compose.
+func (state *State[T]) upsert(name string, text T, merge func(old, new T) T) error {
+	for {
+		if node, exists := state.get(name); exists {
+			unlockWAL := state.lockWAL()
+			node.lock.Lock()
+			if node.dead.Load() {
+				// Removed since get; try again against whatever is stored now.
+				node.lock.Unlock()
+				unlockWAL()
+				continue
+			}
+			merged := state.intern(merge(node.text, text))
+			if err := state.log(Op[T]{Kind: OpUpdate, Name: name, Text: merged}); err != nil {
+				node.lock.Unlock()
+				unlockWAL()
+				return err
+			}
+			node.replaceText(merged)
+			state.counted(merged)
+			node.lock.Unlock()
+			state.publish(Event{Kind: OpUpdate, Name: name})
+			unlockWAL()
+			return nil
+		}
+		err := state.create(name, text)
+		if _, exists := state.get(name); err == nil || !exists {
+			return err
+		}
+	}
+}
+
+// rename moves a node from oldName to newName.
+// A node's name keys its entry in every parent's children map and is read without locks,
+// so names are never mutated in place. Instead rename builds a replacement node carrying the
+// same text and children, re-points every parent (found via back-references) at it, and retires
+// the old node as dead.
+// newName is claimed before any edge is wired, so a failed rename leaves no back-reference to a
+// node that was never stored. If a parent filled up concurrently and cannot take the new edge,
+// the rename still stands and the returned error names that parent.
+// Any stale parent entries left behind are reclaimed by the usual dead-pointer cleanup.
+func (state *State[T]) rename(oldName, newName string) error {
+	defer state.lockWAL()()
+	oldNode, exists := state.get(oldName)
+	if !exists {
+		return ErrNodeNotFound
+	}
+	if err := state.validateName(newName); err != nil {
+		return err
+	}
+	if err := state.log(Op[T]{Kind: OpRename, Name: oldName, Other: newName}); err != nil {
+		return err
+	}
+	newNode := state.newNode(newName, oldNode.getText())
+	newNode.version.Store(oldNode.version.Load())
+	newNode.created = oldNode.created
+	newNode.modified.Store(oldNode.modified.Load())
+	newNode.expires = oldNode.expires
+	parentLabels := make(map[*Node[T]][]string)
+	for _, parent := range oldNode.getParents() {
+		if parent.child(oldName) == oldNode {
+			parentLabels[parent] = parent.edgeLabels(oldName)
+		}
+	}
+	if _, loaded := state.nodes.LoadOrStore(newName, newNode); loaded {
+		return ErrNodeExists
+	}
+	var wired []*Node[T]
+	// abandon gives newName back and takes down every edge wired to newNode so far.
+	// Marking it dead also prunes anything connected to it through newName in the meantime.
+	abandon := func() {
+		state.nodes.CompareAndDelete(newName, newNode)
+		newNode.dead.Store(true)
+		for _, child := range wired {
+			unlink(newNode, child)
+		}
+	}
+	for _, child := range oldNode.getValidChildren() {
+		wired = append(wired, child)
+		for _, label := range oldNode.edgeLabels(child.name) {
+			if err := link(newNode, child, label); err != nil {
+				abandon()
+				return err
+			}
+		}
+	}
+	if !state.nodes.CompareAndDelete(oldName, oldNode) {
+		// oldName was removed or replaced concurrently, give the new name back.
+		abandon()
+		return ErrNodeNotFound
+	}
+	// Retire the old node before re-pointing parents, so it never holds a slot next to its replacement.
+	oldNode.dead.Store(true)
+	var errs []error
+	for parent, labels := range parentLabels {
+		for _, label := range labels {
+			if err := link(parent, newNode, label); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", parent.name, err))
+			}
+		}
+	}
+	state.publish(Event{Kind: OpRename, Name: oldName, Other: newName})
+	return errors.Join(errs...)
+}
+
+// CleanupAll runs cleanup on every node, reclaiming stale child and parent slots immediately.
+// Range holds none of our locks, so taking each node's write lock inside it cannot deadlock.
+func (state *State[T]) CleanupAll() {
+	state.Range(func(_ string, node *Node[T]) bool {
+		node.cleanup()
+		return true
+	})
+}
+
+// StartCleaner runs CleanupAll every interval on a background goroutine.
+// The returned stop function cancels it and waits for the goroutine to exit; calling it again is a no-op.
+// A non-positive interval starts no goroutine and returns a stop that does nothing.
+func (state *State[T]) StartCleaner(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				state.CleanupAll()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(quit) })
+		<-done
+	}
+}
+
+// Range calls f for each node in State until f returns false. Expired nodes are retired and skipped.
+// Like sync.Map.Range it is weakly consistent: nodes created or removed during the call may or
+// may not be visited, so a node passed to f can already be dead.
+func (state *State[T]) Range(f func(name string, n *Node[T]) bool) {
+	state.nodes.Range(func(key, value any) bool {
+		name, node := key.(string), value.(*Node[T])
+		if node.expired() {
+			state.expire(name, node)
+			return true
+		}
+		return f(name, node)
+	})
+}
+
+func (state *State[T]) get(name string) (*Node[T], bool) {
 	rawValue, exists := state.nodes.Load(name)
 	if !exists {
 		return nil, false
 	}
-	return rawValue.(*Node), true
+	node := rawValue.(*Node[T])
+	if node.expired() {
+		state.expire(name, node)
+		return nil, false
+	}
+	return node, true
+}
+
+// existsMany reports, for each requested name, whether it currently exists.
+// Each name is looked up on its own, so the answers are not a consistent snapshot.
+func (state *State[T]) existsMany(names []string) map[string]bool {
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		_, exists[name] = state.get(name)
+	}
+	return exists
 }
 
-func (state *State) connect(parent, child string) error {
+// isDead reports whether the node called name is dead, and whether any node by that name was
+// found at all. A stored node is found directly; a removed one is found only while a dead
+// pointer to it still lingers in some other node's tables, which takes a scan of every edge.
+// Pointers are only read, never reset.
+func (state *State[T]) isDead(name string) (dead bool, found bool) {
+	if rawValue, exists := state.nodes.Load(name); exists {
+		node := rawValue.(*Node[T])
+		return node.dead.Load() || node.expired(), true
+	}
+	state.nodes.Range(func(_, value any) bool {
+		node := value.(*Node[T])
+		node.lock.RLock()
+		defer node.lock.RUnlock()
+		for _, table := range []map[string]*atomic.Pointer[Node[T]]{node.children, node.parents} {
+			if ptr, exists := table[name]; exists {
+				if target := ptr.Load(); target != nil {
+					dead, found = target.dead.Load(), true
+					return false
+				}
+			}
+		}
+		return true
+	})
+	return dead, found
+}
+
+// connect adds a parent -> child edge tagged with label. Connecting an existing edge
+// under another label adds that label, so one edge can carry several.
+func (state *State[T]) connect(parent, child, label string) error {
+	defer state.lockWAL()()
+	if parent == child {
+		return ErrSelfLoop
+	}
+	parentNode, parentExists := state.get(parent)
+	childNode, childExists := state.get(child)
+	if !parentExists || !childExists {
+		return ErrNodeNotFound
+	}
+	// Refused edges must not reach the log; lockWAL keeps this answer good until link.
+	if !parentNode.fits(childNode) {
+		return ErrChildLimit
+	}
+	if err := state.log(Op[T]{Kind: OpConnect, Name: parent, Other: child, Label: label}); err != nil {
+		return err
+	}
+	if err := link(parentNode, childNode, label); err != nil {
+		return err
+	}
+	state.publish(Event{Kind: OpConnect, Name: parent, Other: child})
+	return nil
+}
+
+// connectChecked is connect but refuses edges that would close a cycle.
+// The check and the wiring are not atomic, so two concurrent calls can still race into a cycle.
+func (state *State[T]) connectChecked(parent, child, label string) error {
+	defer state.lockWAL()()
+	if parent == child {
+		return ErrSelfLoop
+	}
+	parentNode, parentExists := state.get(parent)
+	childNode, childExists := state.get(child)
+	if !parentExists || !childExists {
+		return ErrNodeNotFound
+	}
+	if reaches(childNode, parentNode) {
+		return ErrWouldCycle
+	}
+	if !parentNode.fits(childNode) {
+		return ErrChildLimit
+	}
+	if err := state.log(Op[T]{Kind: OpConnect, Name: parent, Other: child, Label: label}); err != nil {
+		return err
+	}
+	if err := link(parentNode, childNode, label); err != nil {
+		return err
+	}
+	state.publish(Event{Kind: OpConnect, Name: parent, Other: child})
+	return nil
+}
+
+// connectMany wires every existing child to parent under label, taking the parent's lock once.
+// Children that do not exist, are the parent itself, or do not fit under maxChildren are skipped
+// and reported in the returned error.
+func (state *State[T]) connectMany(parent string, children []string, label string) error {
+	defer state.lockWAL()()
+	parentNode, exists := state.get(parent)
+	if !exists {
+		return ErrNodeNotFound
+	}
+	var found []*Node[T]
+	var missing []string
+	var errs []error
+	for _, child := range children {
+		if child == parent {
+			errs = append(errs, ErrSelfLoop)
+			continue
+		}
+		childNode, exists := state.get(child)
+		if !exists {
+			missing = append(missing, child)
+			continue
+		}
+		found = append(found, childNode)
+	}
+	// Each edge is logged under the parent's lock once it is known to fit, so refused edges never reach the log.
+	added, rejected, err := parentNode.addChildren(found, label, func(childNode *Node[T]) error {
+		return state.log(Op[T]{Kind: OpConnect, Name: parent, Other: childNode.name, Label: label})
+	})
+	for _, childNode := range added {
+		childNode.addParent(parentNode)
+		state.publish(Event{Kind: OpConnect, Name: parent, Other: childNode.name})
+	}
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrNodeNotFound, strings.Join(missing, ", ")))
+	}
+	if len(rejected) > 0 {
+		errs = append(errs, fmt.Errorf("%w, %d children not connected", ErrChildLimit, len(rejected)))
+	}
+	return errors.Join(errs...)
+}
+
+// connectOnce is connect but reports whether a new edge (or a new label on an edge) was created.
+// An edge that already carries label is left untouched, pointer included.
+func (state *State[T]) connectOnce(parent, child, label string) (bool, error) {
+	defer state.lockWAL()()
+	if parent == child {
+		return false, ErrSelfLoop
+	}
 	parentNode, parentExists := state.get(parent)
 	childNode, childExists := state.get(child)
 	if !parentExists || !childExists {
-		return errors.New("one or both nodes do not exist")
+		return false, ErrNodeNotFound
+	}
+	if !parentNode.fits(childNode) {
+		return false, ErrChildLimit
+	}
+	if err := state.log(Op[T]{Kind: OpConnect, Name: parent, Other: child, Label: label}); err != nil {
+		return false, err
+	}
+	if added, err := parentNode.addChildOnce(childNode, label); !added {
+		return false, err
+	}
+	childNode.addParent(parentNode)
+	state.publish(Event{Kind: OpConnect, Name: parent, Other: child})
+	return true, nil
+}
+
+// disconnect removes the parent -> child edge along with every label on it.
+func (state *State[T]) disconnect(parent, child string) error {
+	defer state.lockWAL()()
+	parentNode, parentExists := state.get(parent)
+	childNode, childExists := state.get(child)
+	if !parentExists || !childExists {
+		return ErrNodeNotFound
+	}
+	if err := state.log(Op[T]{Kind: OpDisconnect, Name: parent, Other: child}); err != nil {
+		return err
+	}
+	if !unlink(parentNode, childNode) {
+		return ErrEdgeNotFound
+	}
+	state.publish(Event{Kind: OpDisconnect, Name: parent, Other: child})
+	return nil
+}
+
+// disconnectAll removes every edge out of parent, returning how many live edges it dropped.
+// The children themselves stay in the store.
+func (state *State[T]) disconnectAll(parent string) (int, error) {
+	defer state.lockWAL()()
+	parentNode, exists := state.get(parent)
+	if !exists {
+		return 0, ErrNodeNotFound
+	}
+	parentNode.lock.Lock()
+	var dropped []*Node[T]
+	for _, ptr := range parentNode.children {
+		if child := ptr.Load(); child != nil && !child.dead.Load() {
+			dropped = append(dropped, child)
+		}
+	}
+	for _, child := range dropped {
+		if err := state.log(Op[T]{Kind: OpDisconnect, Name: parent, Other: child.name}); err != nil {
+			parentNode.lock.Unlock()
+			return 0, err
+		}
+	}
+	clear(parentNode.children)
+	clear(parentNode.labels)
+	parentNode.cleanupCounter.Store(0)
+	parentNode.lock.Unlock()
+	// Back-references are dropped after the parent's lock is released, as in unlink.
+	for _, child := range dropped {
+		child.removeParent(parentNode)
+		state.publish(Event{Kind: OpDisconnect, Name: parent, Other: child.name})
+	}
+	return len(dropped), nil
+}
+
+// lockNodesOrdered write-locks every distinct node in nodes in id order, so two callers
+// locking overlapping sets can never deadlock, and returns a func that unlocks them all.
+func lockNodesOrdered[T any](nodes ...*Node[T]) (unlock func()) {
+	ordered := slices.Clone(nodes)
+	slices.SortFunc(ordered, func(a, b *Node[T]) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	ordered = slices.Compact(ordered)
+	for _, node := range ordered {
+		node.lock.Lock()
+	}
+	return func() {
+		for _, node := range slices.Backward(ordered) {
+			node.lock.Unlock()
+		}
+	}
+}
+
+// reparent moves the edge oldParent -> child, with all of its labels, to newParent.
+// All three nodes are locked together for the move, so readers see child under exactly one
+// of the two parents. If the new edge cannot be wired, the old edge is left in place.
+func (state *State[T]) reparent(child, oldParent, newParent string) error {
+	defer state.lockWAL()()
+	if child == newParent {
+		return ErrSelfLoop
+	}
+	childNode, childExists := state.get(child)
+	oldNode, oldExists := state.get(oldParent)
+	newNode, newExists := state.get(newParent)
+	if !childExists || !oldExists || !newExists {
+		return ErrNodeNotFound
+	}
+	if oldNode == newNode {
+		oldNode.lock.RLock()
+		defer oldNode.lock.RUnlock()
+		if ptr, exists := oldNode.children[child]; !exists || ptr.Load() != childNode {
+			return ErrEdgeNotFound
+		}
+		return nil
+	}
+	if err := state.moveEdge(childNode, oldNode, newNode); err != nil {
+		return err
+	}
+	state.publish(Event{Kind: OpConnect, Name: newParent, Other: child})
+	state.publish(Event{Kind: OpDisconnect, Name: oldParent, Other: child})
+	return nil
+}
+
+// moveEdge does the locked part of reparent for two distinct parents.
+func (state *State[T]) moveEdge(childNode, oldNode, newNode *Node[T]) error {
+	unlock := lockNodesOrdered(childNode, oldNode, newNode)
+	defer unlock()
+	if ptr, exists := oldNode.children[childNode.name]; !exists || ptr.Load() != childNode || childNode.dead.Load() {
+		return ErrEdgeNotFound
+	}
+	if newNode.dead.Load() {
+		return ErrNodeNotFound
+	}
+	return state.transferLocked(childNode, oldNode, newNode)
+}
+
+// transferLocked moves the live edge oldNode -> childNode, labels and back-reference included,
+// to newNode, logging it as connects followed by a disconnect. Nothing changes if newNode is
+// full. The caller must hold all three write locks.
+func (state *State[T]) transferLocked(childNode, oldNode, newNode *Node[T]) error {
+	child := childNode.name
+	if !newNode.fitsLocked(childNode) {
+		return ErrChildLimit
+	}
+	labels := slices.Sorted(maps.Keys(oldNode.labels[child]))
+	for _, label := range labels {
+		if err := state.log(Op[T]{Kind: OpConnect, Name: newNode.name, Other: child, Label: label}); err != nil {
+			return err
+		}
+	}
+	if err := state.log(Op[T]{Kind: OpDisconnect, Name: oldNode.name, Other: child}); err != nil {
+		return err
+	}
+	for _, label := range labels {
+		newNode.putChild(childNode, label)
+	}
+	childNode.putParent(newNode)
+	oldNode.deleteChild(childNode)
+	childNode.deleteParent(oldNode)
+	return nil
+}
+
+// replaceChild swaps the edge parent -> oldChild for parent -> newChild, carrying its labels
+// over. The three nodes are locked together, so readers see exactly one of the two edges.
+// If newChild is already a child, the labels are merged into that edge.
+func (state *State[T]) replaceChild(parent, oldChild, newChild string) error {
+	defer state.lockWAL()()
+	if parent == newChild {
+		return ErrSelfLoop
+	}
+	parentNode, parentExists := state.get(parent)
+	oldNode, oldExists := state.get(oldChild)
+	newNode, newExists := state.get(newChild)
+	if !parentExists || !oldExists || !newExists {
+		return ErrNodeNotFound
+	}
+	if oldNode == newNode {
+		return nil
+	}
+	if err := state.replaceEdge(parentNode, oldNode, newNode); err != nil {
+		return err
+	}
+	state.publish(Event{Kind: OpConnect, Name: parent, Other: newChild})
+	state.publish(Event{Kind: OpDisconnect, Name: parent, Other: oldChild})
+	return nil
+}
+
+// replaceEdge does the locked part of replaceChild.
+func (state *State[T]) replaceEdge(parentNode, oldNode, newNode *Node[T]) error {
+	unlock := lockNodesOrdered(parentNode, oldNode, newNode)
+	defer unlock()
+	if ptr, exists := parentNode.children[oldNode.name]; !exists || ptr.Load() != oldNode || oldNode.dead.Load() {
+		return ErrEdgeNotFound
+	}
+	if newNode.dead.Load() || parentNode.dead.Load() {
+		return ErrNodeNotFound
+	}
+	labels := slices.Sorted(maps.Keys(parentNode.labels[oldNode.name]))
+	for _, label := range labels {
+		if err := state.log(Op[T]{Kind: OpConnect, Name: parentNode.name, Other: newNode.name, Label: label}); err != nil {
+			return err
+		}
+	}
+	if err := state.log(Op[T]{Kind: OpDisconnect, Name: parentNode.name, Other: oldNode.name}); err != nil {
+		return err
+	}
+	// Drop the old edge first so the new one never counts against maxChildren.
+	parentNode.deleteChild(oldNode)
+	oldNode.deleteParent(parentNode)
+	for _, label := range labels {
+		parentNode.putChild(newNode, label)
 	}
-	parentNode.addChild(childNode)
+	newNode.putParent(parentNode)
 	return nil
 }
 
-func (state *State) show(name string) string {
+// swapChildren exchanges the label sets on the edges parent -> childA and parent -> childB
+// under the parent's write lock, which reorders label-based views such as childrenByLabel
+// without a window where either edge is missing. Subscribers see both edges disconnected and
+// connected again, the ops the swap is logged as.
+func (state *State[T]) swapChildren(parent, childA, childB string) error {
+	defer state.lockWAL()()
+	parentNode, exists := state.get(parent)
+	if !exists {
+		return ErrNodeNotFound
+	}
+	if err := state.swapLabels(parentNode, childA, childB); err != nil || childA == childB {
+		return err
+	}
+	for _, kind := range []OpKind{OpDisconnect, OpConnect} {
+		state.publish(Event{Kind: kind, Name: parent, Other: childA})
+		state.publish(Event{Kind: kind, Name: parent, Other: childB})
+	}
+	return nil
+}
+
+// swapLabels does the locked part of swapChildren.
+func (state *State[T]) swapLabels(parentNode *Node[T], childA, childB string) error {
+	parent := parentNode.name
+	parentNode.lock.Lock()
+	defer parentNode.lock.Unlock()
+	for _, child := range []string{childA, childB} {
+		ptr, exists := parentNode.children[child]
+		if !exists {
+			return ErrEdgeNotFound
+		}
+		if target := ptr.Load(); target == nil || target.dead.Load() {
+			return ErrEdgeNotFound
+		}
+	}
+	if childA == childB {
+		return nil
+	}
+	labelsA := slices.Sorted(maps.Keys(parentNode.labels[childA]))
+	labelsB := slices.Sorted(maps.Keys(parentNode.labels[childB]))
+	ops := []Op[T]{
+		{Kind: OpDisconnect, Name: parent, Other: childA},
+		{Kind: OpDisconnect, Name: parent, Other: childB},
+	}
+	for _, label := range labelsB {
+		ops = append(ops, Op[T]{Kind: OpConnect, Name: parent, Other: childA, Label: label})
+	}
+	for _, label := range labelsA {
+		ops = append(ops, Op[T]{Kind: OpConnect, Name: parent, Other: childB, Label: label})
+	}
+	for _, op := range ops {
+		if err := state.log(op); err != nil {
+			return err
+		}
+	}
+	parentNode.labels[childA], parentNode.labels[childB] = parentNode.labels[childB], parentNode.labels[childA]
+	return nil
+}
+
+// moveChildren moves every live child of from, with its labels, under to, returning how many
+// moved. The children are locked together with both parents, so each one is seen under exactly
+// one of them. A child that is to itself stays put. If to fills up, the move stops there and
+// the children moved so far stay moved.
+func (state *State[T]) moveChildren(from, to string) (int, error) {
+	defer state.lockWAL()()
+	fromNode, fromExists := state.get(from)
+	toNode, toExists := state.get(to)
+	if !fromExists || !toExists {
+		return 0, ErrNodeNotFound
+	}
+	if fromNode == toNode {
+		return 0, nil
+	}
+	children := slices.DeleteFunc(fromNode.getValidChildren(), func(child *Node[T]) bool {
+		return child == toNode
+	})
+	unlock := lockNodesOrdered(append(children, fromNode, toNode)...)
+	var moved []string
+	var err error
+	if toNode.dead.Load() {
+		err = ErrNodeNotFound
+	}
+	slices.SortFunc(children, func(a, b *Node[T]) int {
+		return strings.Compare(a.name, b.name)
+	})
+	for _, childNode := range children {
+		if err != nil {
+			break
+		}
+		// Skip children unlinked or removed between the snapshot and taking the locks.
+		if ptr, exists := fromNode.children[childNode.name]; !exists || ptr.Load() != childNode || childNode.dead.Load() {
+			continue
+		}
+		if err = state.transferLocked(childNode, fromNode, toNode); err == nil {
+			moved = append(moved, childNode.name)
+		}
+	}
+	unlock()
+	for _, child := range moved {
+		state.publish(Event{Kind: OpConnect, Name: to, Other: child})
+		state.publish(Event{Kind: OpDisconnect, Name: from, Other: child})
+	}
+	return len(moved), err
+}
+
+// NodeView is a snapshot of a node for callers that do their own formatting.
+type NodeView[T any] struct {
+	Name     string
+	Text     T
+	Children []string // live children, sorted by name
+}
+
+// describe snapshots the node called name, reporting false if it does not exist.
+func (state *State[T]) describe(name string) (NodeView[T], bool) {
 	node, exists := state.get(name)
+	if !exists {
+		return NodeView[T]{}, false
+	}
+	view := NodeView[T]{Name: name, Text: node.getText(), Children: []string{}}
+	for _, child := range node.getValidChildren() {
+		view.Children = append(view.Children, child.name)
+	}
+	slices.Sort(view.Children)
+	return view, true
+}
+
+// show formats a node and its children, sorted by name, rendering the payload with format.
+// A nil format falls back to fmt.Sprint.
+func (state *State[T]) show(name string, format func(T) string) string {
+	view, exists := state.describe(name)
 	if !exists {
 		return name + " is empty"
 	}
-	ans := "Node: \"" + node.text + "\"\nChildren:"
-	children := node.getValidChildren()
-	if len(children) == 0 {
+	return view.format(format)
+}
+
+// showMany returns show(name, format) for every name, keyed by name. Missing names map
+// to their "is empty" line. The work is spread over at most GOMAXPROCS goroutines.
+func (state *State[T]) showMany(names []string, format func(T) string) map[string]string {
+	workers := min(len(names), runtime.GOMAXPROCS(0))
+	jobs := make(chan string)
+	var lock sync.Mutex
+	out := make(map[string]string, len(names))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				shown := state.show(name, format)
+				lock.Lock()
+				out[name] = shown
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+	return out
+}
+
+// format renders the view the way show does.
+func (view NodeView[T]) format(format func(T) string) string {
+	if format == nil {
+		format = func(text T) string { return fmt.Sprint(text) }
+	}
+	ans := "Node: \"" + format(view.Text) + "\"\nChildren:"
+	if len(view.Children) == 0 {
 		ans += " None"
 	} else {
-		for _, child := range children {
-			ans += "\n - " + child.name
+		for _, child := range view.Children {
+			ans += "\n - " + child
 		}
 	}
 	return ans
 }
 
 func main() {
-	st := &State{}
+	demo := flag.Bool("demo", false, "run the cleanup demo instead of the REPL")
+	flag.Parse()
+
+	st := &State[string]{}
+	stop := st.StartCleaner(10 * time.Millisecond)
+	defer stop()
 
+	if *demo {
+		runDemo(st)
+		return
+	}
+	RunREPL(st, os.Stdin, os.Stdout)
+}
+
+// runDemo fills a parent with 200 children, removes them all and shows the parent again.
+func runDemo(st *State[string]) {
 	// Create parent and some children.
 	st.create("A", "Parent Node")
 	// Create 200 children for node A.
+	children := make(map[string]string)
 	for i := 1; i <= 200; i++ {
-		childName := fmt.Sprintf("B%d", i)
-		st.create(childName, fmt.Sprintf("Child Node %d", i))
-		st.connect("A", childName)
+		children[fmt.Sprintf("B%d", i)] = fmt.Sprintf("Child Node %d", i)
 	}
+	names, _ := st.createMany(children)
+	st.connectMany("A", names, "")
 
 	fmt.Println("Before deletion, A's children:")
-	fmt.Println(st.show("A"))
+	fmt.Println(st.show("A", nil))
 
 	// Remove all children from A to simulate garbage.
-	for i := 1; i <= 200; i++ {
-		childName := fmt.Sprintf("B%d", i)
-		st.remove(childName)
-	}
-
-	// Allow some time for goroutines to run (if any) and cleanup to be triggered.
-	time.Sleep(100 * time.Millisecond)
+	st.removeMany(names)
 
-	fmt.Println("\nAfter removal of children, A's children (cleanup should trigger):")
-	fmt.Println(st.show("A"))
+	// The background cleaner reclaims the dead slots, show already skips them.
+	fmt.Println("\nAfter removal of children, A's children:")
+	fmt.Println(st.show("A", nil))
 }
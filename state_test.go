@@ -0,0 +1,1198 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpdate(t *testing.T) {
+	st := &State[string]{}
+	if err := st.update("x", "y"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("update of a missing node = %v, want ErrNodeNotFound", err)
+	}
+	st.create("A", "old")
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				st.update("A", "new")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				if s := st.show("A", nil); !strings.Contains(s, `"old"`) && !strings.Contains(s, `"new"`) {
+					t.Errorf("show saw a torn text: %q", s)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if a, _ := st.get("A"); a.getText() != "new" {
+		t.Fatalf("text = %q, want new", a.getText())
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "a")
+	st.create("B", "b")
+	st.connect("A", "B", "")
+	if err := st.disconnect("A", "B"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.disconnect("A", "B"); !errors.Is(err, ErrEdgeNotFound) {
+		t.Fatalf("second disconnect = %v, want ErrEdgeNotFound", err)
+	}
+	if err := st.disconnect("A", "Z"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("disconnect from a missing child = %v, want ErrNodeNotFound", err)
+	}
+	if got := st.show("A", nil); strings.Contains(got, "B") {
+		t.Fatalf("B still listed:\n%s", got)
+	}
+	if _, exists := st.get("B"); !exists {
+		t.Fatal("disconnect removed the child node")
+	}
+}
+
+func TestRename(t *testing.T) {
+	st := &State[string]{}
+	st.create("P", "p")
+	st.create("A", "a")
+	st.create("C", "c")
+	st.connect("P", "A", "")
+	st.connect("A", "C", "")
+	if err := st.rename("A", "C"); !errors.Is(err, ErrNodeExists) {
+		t.Fatalf("rename onto a taken name = %v, want ErrNodeExists", err)
+	}
+	if err := st.rename("Z", "Q"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("rename of a missing node = %v, want ErrNodeNotFound", err)
+	}
+	if err := st.rename("A", "B"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := st.get("A"); exists {
+		t.Fatal("old name still resolves")
+	}
+	p, _ := st.get("P")
+	b := p.child("B")
+	if b == nil || b.child("C") == nil || p.child("A") != nil {
+		t.Fatalf("edges not carried over:\n%s\n%s", st.show("P", nil), st.show("B", nil))
+	}
+}
+
+func TestRemoveReturnsNode(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "a")
+	n, err := st.remove("A")
+	if err != nil || n == nil || !n.dead.Load() || n.text != "a" {
+		t.Fatalf("remove = %v, %v; want the dead node A", n, err)
+	}
+	if n, err := st.remove("A"); !errors.Is(err, ErrNodeNotFound) || n != nil {
+		t.Fatalf("second remove = %v, %v; want nil, ErrNodeNotFound", n, err)
+	}
+}
+
+// nodeNames returns the sorted names of nodes.
+func nodeNames[T any](nodes []*Node[T]) []string {
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestParentBackReferences(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"A", "B", "C"} {
+		st.create(n, n)
+	}
+	st.connect("A", "C", "")
+	st.connect("A", "C", "")
+	st.connect("B", "C", "")
+	c, _ := st.get("C")
+	if len(c.parents) != 2 || !slices.Equal(nodeNames(c.getParents()), []string{"A", "B"}) {
+		t.Fatalf("parents of C = %v, want one entry each for A and B", c.parents)
+	}
+	st.disconnect("B", "C")
+	st.remove("A")
+	if got := c.getParents(); len(got) != 0 {
+		t.Fatalf("parents after disconnect and remove = %v, want none", nodeNames(got))
+	}
+	st.create("P", "")
+	st.connect("P", "C", "")
+	st.rename("C", "D")
+	d, _ := st.get("D")
+	if got := nodeNames(d.getParents()); !slices.Equal(got, []string{"P"}) {
+		t.Fatalf("parents after rename = %v, want [P]", got)
+	}
+}
+
+func TestConnectCheckedRefusesCycles(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"A", "B", "C", "D"} {
+		st.create(n, n)
+	}
+	for _, e := range [][2]string{{"A", "B"}, {"A", "C"}, {"B", "D"}, {"C", "D"}} {
+		if err := st.connectChecked(e[0], e[1], ""); err != nil {
+			t.Fatalf("connectChecked%v on a diamond: %v", e, err)
+		}
+	}
+	if err := st.connectChecked("D", "A", ""); !errors.Is(err, ErrWouldCycle) {
+		t.Fatalf("back edge D->A = %v, want ErrWouldCycle", err)
+	}
+	if err := st.connectChecked("A", "A", ""); !errors.Is(err, ErrSelfLoop) {
+		t.Fatalf("self loop = %v, want ErrSelfLoop", err)
+	}
+}
+
+// churn connects and removes a child named X under a new parent P n times,
+// reading P's children after each removal, and returns P.
+func churn(st *State[string], n int) *Node[string] {
+	st.create("P", "")
+	p, _ := st.get("P")
+	for range n {
+		st.create("X", "")
+		st.connect("P", "X", "")
+		st.remove("X")
+		p.getValidChildren()
+	}
+	return p
+}
+
+func TestCleanupFreq(t *testing.T) {
+	if p := churn(NewState[string](1, 0), 3); len(p.children) != 0 {
+		t.Fatalf("cleanupFreq 1 left %d slots, want 0", len(p.children))
+	}
+	if p := churn(NewState[string](1000, 0), 50); len(p.children) != 1 || p.cleanupCounter.Load() != 1 {
+		t.Fatalf("cleanupFreq 1000 left %d slots and counter %d, want the reused slot and 1", len(p.children), p.cleanupCounter.Load())
+	}
+	if p := churn(NewState[string](0, 0), 100); len(p.children) != 1 {
+		t.Fatalf("default cleanupFreq left %d slots, want 1", len(p.children))
+	}
+}
+
+func TestCleanupAll(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "")
+	for i := range 50 {
+		name := "c" + strconv.Itoa(i)
+		st.create(name, "")
+		st.connect("A", name, "")
+	}
+	for i := range 40 {
+		st.remove("c" + strconv.Itoa(i))
+	}
+	a, _ := st.get("A")
+	st.CleanupAll()
+	if len(a.children) != 10 {
+		t.Fatalf("CleanupAll left %d slots, want the 10 live children", len(a.children))
+	}
+}
+
+func TestStartCleaner(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "")
+	st.create("B", "")
+	st.connect("A", "B", "")
+	st.remove("B")
+	a, _ := st.get("A")
+	stop := st.StartCleaner(time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for {
+		a.lock.RLock()
+		n := len(a.children)
+		a.lock.RUnlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the cleaner never reclaimed the dead slot")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	stop()
+}
+
+func TestCreateMany(t *testing.T) {
+	st := &State[string]{}
+	st.create("B", "")
+	created, err := st.createMany(map[string]string{"A": "a", "B": "b", "C": "c"})
+	if !errors.Is(err, ErrNodeExists) || !strings.Contains(err.Error(), "B") {
+		t.Fatalf("createMany error = %v, want ErrNodeExists naming B", err)
+	}
+	if !slices.Equal(created, []string{"A", "C"}) {
+		t.Fatalf("created = %v, want [A C]", created)
+	}
+	if _, err := st.createMany(map[string]string{"Q": ""}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectMany(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"P": "", "A": "", "B": ""})
+	err := st.connectMany("P", []string{"A", "X", "B", "Y"}, "")
+	if !errors.Is(err, ErrNodeNotFound) || !strings.Contains(err.Error(), "X, Y") {
+		t.Fatalf("connectMany error = %v, want ErrNodeNotFound naming X, Y", err)
+	}
+	p, _ := st.get("P")
+	a, _ := st.get("A")
+	if got := nodeNames(p.getValidChildren()); !slices.Equal(got, []string{"A", "B"}) {
+		t.Fatalf("children = %v, want [A B]", got)
+	}
+	if got := nodeNames(a.getParents()); !slices.Equal(got, []string{"P"}) {
+		t.Fatalf("parents of A = %v, want [P]", got)
+	}
+	if err := st.connectMany("Z", nil, ""); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("connectMany from a missing parent = %v, want ErrNodeNotFound", err)
+	}
+}
+
+// nilSlots counts the child slots of n that hold a nil pointer.
+func nilSlots[T any](n *Node[T]) int64 {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	count := int64(0)
+	for _, ptr := range n.children {
+		if ptr.Load() == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCleanupCounterMatchesNilSlotsUnderContention(t *testing.T) {
+	st := NewState[string](10, 0)
+	st.create("P", "")
+	p, _ := st.get("P")
+	var names []string
+	for i := range 1000 {
+		names = append(names, "c"+strconv.Itoa(i))
+	}
+	for _, n := range names {
+		st.create(n, "")
+	}
+	st.connectMany("P", names, "")
+	var wg sync.WaitGroup
+	for g := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, n := range names {
+				if i%16 == g {
+					st.remove(n)
+				}
+				p.child(names[(i*7)%len(names)])
+				if i%50 == 0 {
+					p.getValidChildren()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	p.getValidChildren()
+	p.lock.RLock()
+	size := len(p.children)
+	p.lock.RUnlock()
+	if size >= len(names)/2+10 {
+		t.Fatalf("children table still holds %d slots, cleanup never ran", size)
+	}
+	if counter, slots := p.cleanupCounter.Load(), nilSlots(p); counter != slots {
+		t.Fatalf("cleanup counter %d, nil slots %d", counter, slots)
+	}
+}
+
+func TestSelfLoopsRefused(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "")
+	if err := st.connect("A", "A", ""); !errors.Is(err, ErrSelfLoop) {
+		t.Fatalf("connect(A, A) = %v, want ErrSelfLoop", err)
+	}
+	if err := st.connectMany("A", []string{"A"}, ""); !errors.Is(err, ErrSelfLoop) {
+		t.Fatalf("connectMany(A, [A]) = %v, want ErrSelfLoop", err)
+	}
+	a, _ := st.get("A")
+	if len(a.children) != 0 || len(a.parents) != 0 {
+		t.Fatalf("self loop left %d children and %d parents", len(a.children), len(a.parents))
+	}
+}
+
+func TestConnectOnce(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "")
+	st.create("B", "")
+	if added, err := st.connectOnce("A", "B", ""); !added || err != nil {
+		t.Fatalf("first connectOnce = %v, %v; want true, nil", added, err)
+	}
+	a, _ := st.get("A")
+	ptr := a.children["B"]
+	if added, err := st.connectOnce("A", "B", ""); added || err != nil {
+		t.Fatalf("repeated connectOnce = %v, %v; want false, nil", added, err)
+	}
+	if a.children["B"] != ptr {
+		t.Fatal("repeated connectOnce replaced the slot pointer")
+	}
+	st.remove("B")
+	a.getValidChildren()
+	st.create("B", "")
+	if added, _ := st.connectOnce("A", "B", ""); !added || a.children["B"] != ptr || a.cleanupCounter.Load() != 0 {
+		t.Fatalf("reconnecting a fresh B: added %v, same slot %v, counter %d", added, a.children["B"] == ptr, a.cleanupCounter.Load())
+	}
+}
+
+type point struct {
+	X int
+	Y string
+}
+
+func TestGenericPayloads(t *testing.T) {
+	ints := &State[int]{}
+	ints.create("A", 7)
+	ints.create("B", 8)
+	ints.connect("A", "B", "")
+	if got, want := ints.show("A", nil), "Node: \"7\"\nChildren:\n - B"; got != want {
+		t.Fatalf("show = %q, want %q", got, want)
+	}
+	points := NewState[point](0, 0)
+	points.create("A", point{1, "x"})
+	format := func(p point) string { return p.Y + strconv.Itoa(p.X) }
+	if got, want := points.show("A", format), "Node: \"x1\"\nChildren: None"; got != want {
+		t.Fatalf("show = %q, want %q", got, want)
+	}
+	saved, _ := points.MarshalJSON()
+	loaded, err := LoadState[point](saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := loaded.get("A"); a.getText() != (point{1, "x"}) {
+		t.Fatalf("reloaded payload = %v", a.getText())
+	}
+}
+
+func TestEdgeLabels(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"A", "B", "C"} {
+		st.create(n, n)
+	}
+	st.connect("A", "B", "owns")
+	st.connect("A", "B", "references")
+	st.connect("A", "C", "references")
+	a, _ := st.get("A")
+	if got := nodeNames(a.childrenByLabel("owns")); !slices.Equal(got, []string{"B"}) {
+		t.Fatalf("owns = %v, want [B]", got)
+	}
+	if got := nodeNames(a.childrenByLabel("references")); !slices.Equal(got, []string{"B", "C"}) {
+		t.Fatalf("references = %v, want [B C]", got)
+	}
+	if n := len(a.getValidChildren()); n != 2 {
+		t.Fatalf("%d children, want 2: labels must not duplicate edges", n)
+	}
+	saved, _ := st.MarshalJSON()
+	if !strings.Contains(string(saved), `"labels":{"B":["owns","references"]`) {
+		t.Fatalf("labels missing from JSON: %s", saved)
+	}
+	loaded, err := LoadState[string](saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := loaded.MarshalJSON(); string(got) != string(saved) {
+		t.Fatalf("reloaded %s, saved %s", got, saved)
+	}
+	st.remove("B")
+	if got := nodeNames(a.childrenByLabel("references")); !slices.Equal(got, []string{"C"}) {
+		t.Fatalf("references after removing B = %v, want [C]", got)
+	}
+	st.CleanupAll()
+	if len(a.labels) != 1 {
+		t.Fatalf("cleanup kept labels for %d edges, want 1", len(a.labels))
+	}
+	st.rename("C", "D")
+	if got := a.edgeLabels("D"); !slices.Equal(got, []string{"references"}) {
+		t.Fatalf("labels after rename = %v, want [references]", got)
+	}
+}
+
+func TestRangeStopsEarly(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"A": "", "B": "", "C": ""})
+	all := 0
+	st.Range(func(string, *Node[string]) bool { all++; return true })
+	first := 0
+	st.Range(func(string, *Node[string]) bool { first++; return false })
+	if all != 3 || first != 1 {
+		t.Fatalf("Range visited %d nodes, and %d when stopped at once; want 3 and 1", all, first)
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	st := &State[string]{}
+	st.create("A", "v0")
+	a, _ := st.get("A")
+	v := a.version.Load()
+	v1, err := st.updateIfVersion("A", "first", v)
+	if err != nil || v1 != v+1 {
+		t.Fatalf("updateIfVersion = %d, %v; want %d, nil", v1, err, v+1)
+	}
+	current, err := st.updateIfVersion("A", "second", v)
+	if !errors.Is(err, ErrVersionConflict) || current != v1 {
+		t.Fatalf("stale updateIfVersion = %d, %v; want %d, ErrVersionConflict", current, err, v1)
+	}
+	if a.getText() != "first" {
+		t.Fatalf("stale update applied: text %q", a.getText())
+	}
+	st.update("A", "x")
+	if a.version.Load() != v1+1 {
+		t.Fatalf("update left version %d, want %d", a.version.Load(), v1+1)
+	}
+}
+
+func TestCreateWithTTL(t *testing.T) {
+	st := &State[string]{}
+	st.create("P", "")
+	if err := st.createWithTTL("T", "", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	st.connect("P", "T", "")
+	tn, exists := st.get("T")
+	if !exists {
+		t.Fatal("node gone before its TTL")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, exists := st.get("T"); exists || !tn.dead.Load() {
+		t.Fatal("node outlived its TTL")
+	}
+	if got, _ := st.childrenOf("P"); len(got) != 0 {
+		t.Fatalf("children after expiry = %v, want none", got)
+	}
+	st.createWithTTL("U", "", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if n, _ := st.Stats(); n != 1 {
+		t.Fatalf("Stats counted %d nodes, want only P", n)
+	}
+	st.createWithTTL("V", "", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if err := st.create("V", ""); err != nil {
+		t.Fatalf("create over an expired name: %v", err)
+	}
+}
+
+func TestMaxChildren(t *testing.T) {
+	st := NewState[string](0, 2)
+	st.createMany(map[string]string{"P": "", "A": "", "B": "", "C": ""})
+	st.connect("P", "A", "")
+	st.connect("P", "B", "")
+	if err := st.connect("P", "C", ""); !errors.Is(err, ErrChildLimit) {
+		t.Fatalf("connect over the limit = %v, want ErrChildLimit", err)
+	}
+	if err := st.connect("P", "B", "second-label"); err != nil {
+		t.Fatalf("labelling an existing edge at the limit: %v", err)
+	}
+	if err := st.connectMany("P", []string{"C"}, ""); !errors.Is(err, ErrChildLimit) {
+		t.Fatalf("connectMany over the limit = %v, want ErrChildLimit", err)
+	}
+	if added, err := st.connectOnce("P", "C", ""); added || !errors.Is(err, ErrChildLimit) {
+		t.Fatalf("connectOnce over the limit = %v, %v; want false, ErrChildLimit", added, err)
+	}
+	if c, _ := st.get("C"); len(c.parents) != 0 {
+		t.Fatalf("refused edges left %d back-references on C", len(c.parents))
+	}
+	st.remove("A")
+	if err := st.connect("P", "C", ""); err != nil {
+		t.Fatalf("connect after freeing a slot: %v", err)
+	}
+	st.rename("B", "B2")
+	if got, _ := st.childrenOf("P"); !slices.Equal(got, []string{"B2", "C"}) {
+		t.Fatalf("children after rename = %v, want [B2 C]", got)
+	}
+}
+
+func TestLiveChildCount(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.create("P", "")
+	for i := range 20 {
+		st.create(strconv.Itoa(i), "")
+		st.connect("P", strconv.Itoa(i), "")
+	}
+	for i := range 7 {
+		st.remove(strconv.Itoa(i))
+	}
+	p, _ := st.get("P")
+	if n := p.liveChildCount(); n != 13 || len(p.getValidChildren()) != 13 {
+		t.Fatalf("liveChildCount = %d, want 13", n)
+	}
+}
+
+// benchParent returns a node with 1000 live children.
+func benchParent(b *testing.B) *Node[string] {
+	b.Helper()
+	st := NewState[string](0, 0)
+	st.create("P", "")
+	for i := range 1000 {
+		st.create(strconv.Itoa(i), "")
+		st.connect("P", strconv.Itoa(i), "")
+	}
+	p, _ := st.get("P")
+	return p
+}
+
+func BenchmarkLiveChildCount(b *testing.B) {
+	p := benchParent(b)
+	for range b.N {
+		p.liveChildCount()
+	}
+}
+
+func BenchmarkLenValidChildren(b *testing.B) {
+	p := benchParent(b)
+	for range b.N {
+		_ = len(p.getValidChildren())
+	}
+}
+
+func TestReconnectReusesSlot(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "", "C": ""})
+	st.connect("P", "C", "")
+	p, _ := st.get("P")
+	first := p.children["C"]
+	st.connect("P", "C", "x")
+	st.remove("C")
+	st.create("C", "")
+	st.connect("P", "C", "")
+	if p.children["C"] != first || p.child("C") == nil {
+		t.Fatal("reconnecting C did not reuse its slot")
+	}
+	n := NewNode("n", "")
+	n.children = nil
+	n.labels = nil
+	n.addChild(NewNode("m", ""), "")
+	if n.child("m") == nil {
+		t.Fatal("addChild on a node with nil tables lost the edge")
+	}
+}
+
+func TestRemoveMany(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "", "b": "", "c": ""})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st.removeMany([]string{"zz"})
+		}()
+	}
+	wg.Wait()
+	removed, missing := st.removeMany([]string{"a", "x", "c", "a"})
+	if !slices.Equal(removed, []string{"a", "c"}) || !slices.Equal(missing, []string{"x", "a"}) {
+		t.Fatalf("removeMany = %v, %v; want [a c], [x a]", removed, missing)
+	}
+}
+
+func TestShowSortsChildren(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "root", "z": "", "a": "", "m": ""})
+	for _, c := range []string{"z", "a", "m"} {
+		st.connect("P", c, "")
+	}
+	want := "Node: \"root\"\nChildren:\n - a\n - m\n - z"
+	for range 20 {
+		if got := st.show("P", nil); got != want {
+			t.Fatalf("show = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "root", "z": "", "a": ""})
+	st.connect("P", "z", "")
+	st.connect("P", "a", "")
+	view, exists := st.describe("P")
+	if !exists || view.Name != "P" || view.Text != "root" || !slices.Equal(view.Children, []string{"a", "z"}) {
+		t.Fatalf("describe(P) = %+v, %v", view, exists)
+	}
+	if _, exists := st.describe("nope"); exists {
+		t.Fatal("describe found a missing node")
+	}
+}
+
+func TestReparent(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"o": "", "n": "", "c": "", "d": ""})
+	st.connect("o", "c", "x")
+	st.connect("o", "c", "y")
+	st.connect("o", "d", "")
+	if err := st.reparent("c", "o", "n"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.childrenOf("o"); !slices.Equal(got, []string{"d"}) {
+		t.Fatalf("old parent keeps %v, want [d]", got)
+	}
+	if got, _ := st.childrenOf("n"); !slices.Equal(got, []string{"c"}) {
+		t.Fatalf("new parent has %v, want [c]", got)
+	}
+	if n, _ := st.get("n"); !slices.Equal(n.edgeLabels("c"), []string{"x", "y"}) {
+		t.Fatalf("moved labels = %v, want [x y]", n.edgeLabels("c"))
+	}
+	if err := st.reparent("c", "o", "n"); !errors.Is(err, ErrEdgeNotFound) {
+		t.Fatalf("reparent without the old edge = %v, want ErrEdgeNotFound", err)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	st := NewState[string](0, 0)
+	join := func(old, added string) string { return old + "+" + added }
+	st.upsert("a", "x", join)
+	st.upsert("a", "y", join)
+	if a, _ := st.get("a"); a.getText() != "x+y" || a.version.Load() != 1 {
+		t.Fatalf("upsert left %q at version %d, want x+y at 1", a.getText(), a.version.Load())
+	}
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st.upsert("b", "1", join)
+		}()
+	}
+	wg.Wait()
+	if b, _ := st.get("b"); strings.Count(b.getText(), "1") != 50 {
+		t.Fatalf("concurrent upserts lost writes: %q", b.getText())
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.create("a", "x")
+	a, _ := st.get("a")
+	created, modified := a.Created(), a.Modified()
+	if !created.Equal(modified) {
+		t.Fatalf("new node created %v but modified %v", created, modified)
+	}
+	time.Sleep(2 * time.Millisecond)
+	st.update("a", "y")
+	if !a.Modified().After(modified) || !a.Created().Equal(created) {
+		t.Fatal("update must bump Modified and keep Created")
+	}
+	st.rename("a", "b")
+	if b, _ := st.get("b"); !b.Created().Equal(created) {
+		t.Fatal("rename reset Created")
+	}
+}
+
+func TestConcurrentReparentDoesNotDeadlock(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"X": "", "Y": "", "a": "", "b": ""})
+	st.connect("X", "a", "")
+	st.connect("Y", "b", "")
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 500 {
+					st.reparent("a", "X", "Y")
+					st.reparent("b", "Y", "X")
+					st.reparent("a", "Y", "X")
+					st.reparent("b", "X", "Y")
+				}
+			}()
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("concurrent reparents deadlocked")
+	}
+	x, _ := st.reachableCount("X")
+	y, _ := st.reachableCount("Y")
+	if x+y != 4 {
+		t.Fatalf("edges lost or duplicated: %v", st.AdjacencyList())
+	}
+	if err := st.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShowMany(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "x", "b": "y"})
+	st.connect("a", "b", "")
+	got := st.showMany([]string{"a", "b", "zz", "a"}, nil)
+	if len(got) != 3 || got["a"] != st.show("a", nil) || got["zz"] != "zz is empty" {
+		t.Fatalf("showMany = %v", got)
+	}
+	if got := st.showMany(nil, nil); len(got) != 0 {
+		t.Fatalf("showMany(nil) = %v, want empty", got)
+	}
+}
+
+func TestDisconnectAll(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "p", "a": "", "b": "", "c": ""})
+	for _, c := range []string{"a", "b", "c"} {
+		st.connect("P", c, "")
+	}
+	st.remove("c")
+	n, err := st.disconnectAll("P")
+	if err != nil || n != 2 || st.show("P", nil) != "Node: \"p\"\nChildren: None" {
+		t.Fatalf("disconnectAll = %d, %v; want 2 live edges cut", n, err)
+	}
+	if a, exists := st.get("a"); !exists || len(a.getParents()) != 0 {
+		t.Fatal("child kept its back-reference")
+	}
+	if err := st.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsDead(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "", "c": ""})
+	st.connect("P", "c", "")
+	if dead, found := st.isDead("c"); dead || !found {
+		t.Fatalf("isDead(live c) = %v, %v; want false, true", dead, found)
+	}
+	st.remove("c")
+	if dead, found := st.isDead("c"); !dead || !found {
+		t.Fatalf("isDead(removed c) = %v, %v; want true, true", dead, found)
+	}
+	if dead, found := st.isDead("zz"); dead || found {
+		t.Fatalf("isDead(zz) = %v, %v; want false, false", dead, found)
+	}
+}
+
+func TestPendingDead(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.create("P", "")
+	for i := range 10 {
+		st.create(strconv.Itoa(i), "")
+		st.connect("P", strconv.Itoa(i), "")
+	}
+	for i := range 4 {
+		st.remove(strconv.Itoa(i))
+	}
+	p, _ := st.get("P")
+	if p.pendingDead() != 4 || p.pendingDead() != 4 {
+		t.Fatalf("pendingDead = %d, want 4 on repeated calls", p.pendingDead())
+	}
+	p.getValidChildren()
+	if p.pendingDead() != 0 || p.cleanupCounter.Load() != 4 {
+		t.Fatalf("after a scan pendingDead = %d and counter %d, want 0 and 4", p.pendingDead(), p.cleanupCounter.Load())
+	}
+}
+
+func TestCleanupPolicy(t *testing.T) {
+	st := NewState[string](0, 0)
+	var calls atomic.Int64
+	st.CleanupPolicy = func(int64, int) bool {
+		calls.Add(1)
+		return true
+	}
+	st.create("P", "")
+	for i := range 10 {
+		st.create(strconv.Itoa(i), "")
+		st.connect("P", strconv.Itoa(i), "")
+	}
+	p, _ := st.get("P")
+	for i := range 5 {
+		st.remove(strconv.Itoa(i))
+		p.getValidChildren()
+		if len(p.children) != 9-i || p.cleanupCounter.Load() != 0 {
+			t.Fatalf("after %d removals: %d slots, counter %d", i+1, len(p.children), p.cleanupCounter.Load())
+		}
+	}
+	if calls.Load() < 5 {
+		t.Fatalf("policy consulted %d times, want at least 5", calls.Load())
+	}
+	if clone, _ := st.Clone(); clone.CleanupPolicy == nil {
+		t.Fatal("Clone dropped the cleanup policy")
+	}
+}
+
+func TestChildrenByNames(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "", "a": "", "b": "", "c": ""})
+	st.connect("P", "a", "")
+	st.connect("P", "b", "")
+	st.remove("b")
+	p, _ := st.get("P")
+	if got := p.childrenByNames([]string{"a", "b", "c", "zz"}); len(got) != 1 || got["a"] == nil {
+		t.Fatalf("childrenByNames = %v, want only a", got)
+	}
+}
+
+func TestReadersDoNotCleanUpUnderReadLock(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.CleanupPolicy = func(int64, int) bool { return true }
+	st.create("P", "")
+	for i := range 50 {
+		st.create(strconv.Itoa(i), "")
+		st.connect("P", strconv.Itoa(i), strconv.Itoa(i%2))
+		st.connect(strconv.Itoa(i), "P", "")
+	}
+	p, _ := st.get("P")
+	done := make(chan struct{})
+	go func() {
+		for i := range 50 {
+			st.remove(strconv.Itoa(i))
+			switch i % 5 {
+			case 0:
+				p.getValidChildren()
+			case 1:
+				p.liveChildCount()
+			case 2:
+				p.childrenByLabel("0")
+			case 3:
+				p.childrenByNames([]string{strconv.Itoa(i)})
+			case 4:
+				p.getParents()
+			}
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock: cleanup ran while the read lock was held")
+	}
+	if p.liveChildCount() != 0 || len(p.getParents()) != 0 {
+		t.Fatalf("%d children and %d parents left, want none", p.liveChildCount(), len(p.getParents()))
+	}
+}
+
+func TestNameValidator(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.SetNameValidator(func(name string) error {
+		if name == "" || strings.Contains(name, " ") {
+			return errors.New("bad name")
+		}
+		return nil
+	})
+	for _, name := range []string{"a b", ""} {
+		if err := st.create(name, ""); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("create(%q) = %v, want ErrInvalidName", name, err)
+		}
+	}
+	if err := st.create("ab", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.rename("ab", "a b"); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("rename to an invalid name = %v, want ErrInvalidName", err)
+	}
+	clone, err := st.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.create("x y", ""); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("Clone dropped the validator: %v", err)
+	}
+	st.SetNameValidator(nil)
+	if err := st.create("a b", ""); err != nil {
+		t.Fatalf("create after clearing the validator: %v", err)
+	}
+}
+
+func TestChildrenMap(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"P": "", "a": "", "b": "", "c": ""})
+	for _, c := range []string{"a", "b", "c"} {
+		st.connect("P", c, "")
+	}
+	st.remove("b")
+	p, _ := st.get("P")
+	got := p.childrenMap()
+	if keys := slices.Sorted(maps.Keys(got)); !slices.Equal(keys, []string{"a", "c"}) || got["a"].name != "a" {
+		t.Fatalf("childrenMap keys = %v, want [a c]", keys)
+	}
+}
+
+func TestMoveChildren(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"F": "", "T": "", "a": "", "b": "", "c": "", "d": ""})
+	st.connect("F", "a", "x")
+	st.connect("F", "b", "")
+	st.connect("F", "c", "")
+	st.connect("F", "T", "")
+	st.connect("T", "d", "")
+	st.remove("c")
+	n, err := st.moveChildren("F", "T")
+	if err != nil || n != 2 {
+		t.Fatalf("moveChildren = %d, %v; want 2, nil", n, err)
+	}
+	if got, _ := st.childrenOf("F"); !slices.Equal(got, []string{"T"}) {
+		t.Fatalf("F keeps %v, want [T]", got)
+	}
+	if got, _ := st.childrenOf("T"); !slices.Equal(got, []string{"a", "b", "d"}) {
+		t.Fatalf("T has %v, want [a b d]", got)
+	}
+	if a, _ := st.get("a"); !slices.Equal(nodeNames(a.getParents()), []string{"T"}) {
+		t.Fatalf("parents of a = %v, want [T]", nodeNames(a.getParents()))
+	}
+	if err := st.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	limited := NewState[string](0, 2)
+	limited.createMany(map[string]string{"F": "", "T": "", "a": "", "b": "", "c": ""})
+	limited.connectMany("F", []string{"a", "b"}, "")
+	limited.connect("T", "c", "")
+	if n, err := limited.moveChildren("F", "T"); n != 1 || !errors.Is(err, ErrChildLimit) {
+		t.Fatalf("moveChildren into a full node = %d, %v; want 1, ErrChildLimit", n, err)
+	}
+}
+
+func TestConcurrentMoveChildrenDoesNotDeadlock(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"X": "", "Y": ""})
+	for i := range 20 {
+		st.create(strconv.Itoa(i), "")
+		st.connect("X", strconv.Itoa(i), "")
+	}
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for g := range 6 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 200 {
+					if g%2 == 0 {
+						st.moveChildren("X", "Y")
+					} else {
+						st.moveChildren("Y", "X")
+					}
+					st.reparent(strconv.Itoa(i%20), "X", "Y")
+				}
+			}()
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("concurrent moves deadlocked")
+	}
+	adj := st.AdjacencyList()
+	if x, y := adj["X"], adj["Y"]; len(x)+len(y) != 20 {
+		t.Fatalf("X has %v and Y has %v, want 20 children between them", x, y)
+	}
+}
+
+func TestNodeLimit(t *testing.T) {
+	st := NewStateWithLimit[string](10)
+	var wg sync.WaitGroup
+	var created atomic.Int64
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if st.create(strconv.Itoa(i), "") == nil {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if created.Load() != 10 || st.Count() != 10 {
+		t.Fatalf("%d creates succeeded and Count = %d, want 10 and 10", created.Load(), st.Count())
+	}
+	var name string
+	st.Range(func(n string, _ *Node[string]) bool {
+		name = n
+		return false
+	})
+	if err := st.create(name, ""); !errors.Is(err, ErrNodeExists) {
+		t.Fatalf("create of an existing name at the limit = %v, want ErrNodeExists", err)
+	}
+	if err := st.create("new", ""); !errors.Is(err, ErrNodeLimit) {
+		t.Fatalf("create at the limit = %v, want ErrNodeLimit", err)
+	}
+	if err := st.rename(name, "renamed"); err != nil {
+		t.Fatalf("rename at the limit: %v", err)
+	}
+	st.remove("renamed")
+	if err := st.create("new", ""); err != nil {
+		t.Fatalf("create after freeing a slot: %v", err)
+	}
+	if err := st.create("new2", ""); !errors.Is(err, ErrNodeLimit) {
+		t.Fatalf("create at the limit again = %v, want ErrNodeLimit", err)
+	}
+}
+
+func TestReplaceAndSwapChildren(t *testing.T) {
+	st := NewState[string](0, 2)
+	st.createMany(map[string]string{"P": "", "a": "", "b": "", "n": ""})
+	st.connect("P", "a", "first")
+	st.connect("P", "b", "second")
+	if err := st.replaceChild("P", "a", "n"); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := st.get("P")
+	a, _ := st.get("a")
+	n, _ := st.get("n")
+	if got, _ := st.childrenOf("P"); !slices.Equal(got, []string{"b", "n"}) || !slices.Equal(p.edgeLabels("n"), []string{"first"}) {
+		t.Fatalf("after replace P has %v with n labelled %v", got, p.edgeLabels("n"))
+	}
+	if len(a.getParents()) != 0 || len(n.getParents()) != 1 {
+		t.Fatal("replaceChild left stale back-references")
+	}
+	if err := st.swapChildren("P", "b", "n"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(p.edgeLabels("n"), []string{"second"}) || p.childrenByLabel("first")[0].name != "b" {
+		t.Fatal("swapChildren did not exchange the labels")
+	}
+	if err := st.replaceChild("P", "a", "n"); !errors.Is(err, ErrEdgeNotFound) {
+		t.Fatalf("replaceChild of a non-child = %v, want ErrEdgeNotFound", err)
+	}
+	if err := st.swapChildren("P", "a", "b"); !errors.Is(err, ErrEdgeNotFound) {
+		t.Fatalf("swapChildren with a non-child = %v, want ErrEdgeNotFound", err)
+	}
+	if err := st.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 2000 {
+			st.replaceChild("P", "n", "a")
+			st.replaceChild("P", "a", "n")
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			if c := p.liveChildCount(); c != 2 {
+				t.Fatalf("readers saw %d children mid-replace, want 2", c)
+			}
+		}
+	}
+}
+
+func TestExistsMany(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "", "b": ""})
+	st.remove("b")
+	want := map[string]bool{"a": true, "b": false, "c": false}
+	if got := st.existsMany([]string{"a", "b", "c"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("existsMany = %v, want %v", got, want)
+	}
+}
+
+func TestCleanupCounter(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.create("P", "")
+	for i := range 10 {
+		st.create(strconv.Itoa(i), "")
+		st.connect("P", strconv.Itoa(i), "")
+	}
+	p, _ := st.get("P")
+	for i := range 3 {
+		st.remove(strconv.Itoa(i))
+		if n := p.CleanupCounter(); n != int64(i) {
+			t.Fatalf("counter %d before touching the dead slot, want %d", n, i)
+		}
+		p.child(strconv.Itoa(i))
+		if n := p.CleanupCounter(); n != int64(i+1) {
+			t.Fatalf("counter %d after touching the dead slot, want %d", n, i+1)
+		}
+	}
+	p.cleanup()
+	if n := p.CleanupCounter(); n != 0 {
+		t.Fatalf("counter %d after cleanup, want 0", n)
+	}
+}
+
+func TestRenameOntoTakenNameLeavesNoBackReference(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"P", "C", "X"} {
+		st.create(n, n)
+	}
+	st.connect("P", "C", "")
+	if err := st.rename("P", "X"); !errors.Is(err, ErrNodeExists) {
+		t.Fatalf("rename onto X = %v, want ErrNodeExists", err)
+	}
+	if got := st.weaklyConnectedComponents(); !slices.EqualFunc(got, [][]string{{"C", "P"}, {"X"}}, slices.Equal) {
+		t.Fatalf("components = %v", got)
+	}
+	if got, _ := st.commonAncestors("C", "C"); !slices.Equal(got, []string{"P"}) {
+		t.Fatalf("ancestors of C = %v, want [P]", got)
+	}
+	if removed, _ := st.removeSubtree("P"); !slices.Equal(removed, []string{"C", "P"}) {
+		t.Fatalf("removeSubtree(P) = %v, want [C P]", removed)
+	}
+}
+
+func TestRenameCloneAndRestoreKeepTTL(t *testing.T) {
+	st := &State[string]{}
+	for _, n := range []string{"A", "B", "C"} {
+		if err := st.createWithTTL(n, n, 20*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.rename("A", "A2"); err != nil {
+		t.Fatal(err)
+	}
+	clone, err := st.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.softRemove("B"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.restore("B"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	for _, n := range []string{"A2", "B", "C"} {
+		if _, exists := st.get(n); exists {
+			t.Errorf("%s outlived its TTL", n)
+		}
+		if _, exists := clone.get(n); exists {
+			t.Errorf("cloned %s outlived its TTL", n)
+		}
+	}
+}
+
+func TestStartCleanerIgnoresNonPositiveInterval(t *testing.T) {
+	st := &State[string]{}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		stop := st.StartCleaner(interval)
+		stop()
+		stop()
+	}
+}
+
+func TestNodeLimitReusesExpiredName(t *testing.T) {
+	st := NewStateWithLimit[string](1)
+	if err := st.createWithTTL("x", "old", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := st.create("x", "new"); err != nil {
+		t.Fatalf("create over an expired node at the limit = %v, want nil", err)
+	}
+	if x, _ := st.get("x"); x == nil || x.getText() != "new" {
+		t.Fatal("x was not recreated")
+	}
+}
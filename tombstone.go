@@ -0,0 +1,83 @@
+package main
+
+import "time"
+
+// tombstone remembers what softRemove took out so restore can rebuild it.
+// Edges are kept by name, since the nodes on the other end may be replaced in the meantime.
+type tombstone[T any] struct {
+	text     T
+	expires  time.Time           // the removed node's TTL deadline, zero if it had none
+	children map[string][]string // child name -> labels
+	parents  map[string][]string // parent name -> labels on the parent's edge
+}
+
+// softRemove removes a node like remove but keeps a tombstone that restore can bring back.
+// The removed node itself is dead for good; restore builds a fresh one in its place.
+func (state *State[T]) softRemove(name string) error {
+	stone, err := state.removeEntombed(name)
+	if err != nil {
+		return err
+	}
+	state.tombstones.Store(name, stone)
+	return nil
+}
+
+// removeEntombed removes name and returns a tombstone describing it.
+func (state *State[T]) removeEntombed(name string) (*tombstone[T], error) {
+	node, exists := state.get(name)
+	if !exists {
+		return nil, ErrNodeNotFound
+	}
+	stone := &tombstone[T]{
+		children: make(map[string][]string),
+		parents:  make(map[string][]string),
+	}
+	for _, child := range node.getValidChildren() {
+		stone.children[child.name] = node.edgeLabels(child.name)
+	}
+	for _, parent := range node.getParents() {
+		if parent.child(name) == node {
+			stone.parents[parent.name] = parent.edgeLabels(name)
+		}
+	}
+	removed, err := state.remove(name)
+	if err != nil {
+		return nil, err
+	}
+	stone.text = removed.getText()
+	stone.expires = removed.expires
+	return stone, nil
+}
+
+// restore recreates a soft-removed node and reconnects every edge whose other end still exists.
+func (state *State[T]) restore(name string) error {
+	rawValue, exists := state.tombstones.Load(name)
+	if !exists {
+		return ErrNoTombstone
+	}
+	stone := rawValue.(*tombstone[T])
+	if err := state.rebuild(name, stone); err != nil {
+		return err
+	}
+	state.tombstones.CompareAndDelete(name, stone)
+	return nil
+}
+
+// rebuild creates name from stone, keeping its TTL deadline, and reconnects every edge whose
+// other end still exists.
+func (state *State[T]) rebuild(name string, stone *tombstone[T]) error {
+	if err := state.createTrusted(name, stone.text, stone.expires); err != nil {
+		return err
+	}
+	for child, labels := range stone.children {
+		for _, label := range labels {
+			state.connect(name, child, label)
+		}
+	}
+	for parent, labels := range stone.parents {
+		for _, label := range labels {
+			state.connect(parent, name, label)
+		}
+	}
+	return nil
+}
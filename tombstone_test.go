@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSoftRemoveAndRestore(t *testing.T) {
+	st := &State[string]{}
+	st.createMany(map[string]string{"P": "", "A": "a", "B": "", "C": ""})
+	st.connect("P", "A", "own")
+	st.connect("A", "B", "")
+	st.connect("A", "C", "")
+	if err := st.softRemove("A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := st.get("A"); exists {
+		t.Fatal("soft-removed node still resolves")
+	}
+	st.remove("C")
+	st.CleanupAll()
+	if err := st.restore("A"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.childrenOf("A"); !slices.Equal(got, []string{"B"}) {
+		t.Fatalf("restored children = %v, want [B] since C is gone", got)
+	}
+	p, _ := st.get("P")
+	if p.child("A") == nil || !slices.Equal(p.edgeLabels("A"), []string{"own"}) {
+		t.Fatalf("parent edge not restored with its label: %v", p.edgeLabels("A"))
+	}
+	if err := st.restore("A"); !errors.Is(err, ErrNoTombstone) {
+		t.Fatalf("second restore = %v, want ErrNoTombstone", err)
+	}
+}
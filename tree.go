@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Tree renders the graph below root as an indented tree in the style of the tree command,
+// with children in name order. A node reachable along several paths is printed under each of
+// them; a node that would repeat one of its own ancestors is printed once more, marked
+// "(cycle)", and not expanded.
+func (state *State[T]) Tree(root string) (string, error) {
+	rootNode, exists := state.get(root)
+	if !exists {
+		return "", ErrNodeNotFound
+	}
+	var out strings.Builder
+	out.WriteString(root + "\n")
+	onPath := map[*Node[T]]bool{rootNode: true}
+	var render func(node *Node[T], prefix string)
+	render = func(node *Node[T], prefix string) {
+		children := node.getValidChildren()
+		slices.SortFunc(children, func(a, b *Node[T]) int {
+			return strings.Compare(a.name, b.name)
+		})
+		for i, child := range children {
+			branch, indent := "├── ", "│   "
+			if i == len(children)-1 {
+				branch, indent = "└── ", "    "
+			}
+			if onPath[child] {
+				out.WriteString(prefix + branch + child.name + " (cycle)\n")
+				continue
+			}
+			out.WriteString(prefix + branch + child.name + "\n")
+			onPath[child] = true
+			render(child, prefix+indent)
+			onPath[child] = false
+		}
+	}
+	render(rootNode, "")
+	return out.String(), nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestTree(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"r": "", "a": "", "b": "", "c": "", "d": ""})
+	st.connect("r", "b", "")
+	st.connect("r", "a", "")
+	st.connect("a", "c", "")
+	st.connect("a", "d", "")
+	st.connect("c", "r", "")
+	st.connect("b", "d", "")
+	want := "r\n├── a\n│   ├── c\n│   │   └── r (cycle)\n│   └── d\n└── b\n    └── d\n"
+	if got, err := st.Tree("r"); err != nil || got != want {
+		t.Fatalf("Tree(r) =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+)
+
+// Validate checks the store's internal invariants and returns one error per violation, joined.
+// Every node in the map must be alive and stored under its own name, every live child pointer
+// must reference the node currently stored under that name, and each cleanup counter must lie
+// between zero and the size of its table. It is a debugging aid: under concurrent mutation a
+// sweep can race the check, so only run it against a quiescent store.
+func (state *State[T]) Validate() error {
+	var problems []string
+	state.Range(func(name string, node *Node[T]) bool {
+		problems = append(problems, state.validateNode(name, node)...)
+		return true
+	})
+	slices.Sort(problems)
+	errs := make([]error, len(problems))
+	for i, problem := range problems {
+		errs[i] = errors.New(problem)
+	}
+	return errors.Join(errs...)
+}
+
+// validateNode returns the invariant violations found on a single node stored under name.
+func (state *State[T]) validateNode(name string, node *Node[T]) []string {
+	var problems []string
+	if node.name != name {
+		problems = append(problems, fmt.Sprintf("node %q is stored under %q", node.name, name))
+	}
+	if node.dead.Load() {
+		problems = append(problems, fmt.Sprintf("node %q is dead but still stored", name))
+	}
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	for childName, ptr := range node.children {
+		child := ptr.Load()
+		if child == nil || child.dead.Load() {
+			continue
+		}
+		if current, _ := state.nodes.Load(childName); current != child {
+			problems = append(problems, fmt.Sprintf("node %q has a live edge to %q, which is not the stored node", name, childName))
+		}
+		if len(node.labels[childName]) == 0 {
+			problems = append(problems, fmt.Sprintf("node %q has an edge to %q with no labels", name, childName))
+		}
+	}
+	problems = append(problems, checkCounter(name, "children", node.children, &node.cleanupCounter)...)
+	problems = append(problems, checkCounter(name, "parents", node.parents, &node.parentCleanupCounter)...)
+	return problems
+}
+
+// checkCounter reports a cleanup counter for table that is negative or larger than the table.
+func checkCounter[T any](name, tableName string, table map[string]*atomic.Pointer[Node[T]], counter *atomic.Int64) []string {
+	if count := counter.Load(); count < 0 || count > int64(len(table)) {
+		return []string{fmt.Sprintf("node %q %s counter is %d with %d entries", name, tableName, count, len(table))}
+	}
+	return nil
+}
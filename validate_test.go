@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	st := NewState[string](0, 0)
+	st.createMany(map[string]string{"a": "", "b": "", "c": ""})
+	st.connect("a", "b", "")
+	st.connect("a", "c", "")
+	st.remove("c")
+	st.CleanupAll()
+	if err := st.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := st.get("a")
+	a.addChild(NewNode("b", ""), "")
+	a.cleanupCounter.Store(-3)
+	b, _ := st.get("b")
+	b.dead.Store(true)
+	err := st.Validate()
+	for _, want := range []string{"not the stored node", "counter is -3", "dead but still stored"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to report %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// OpKind tags the mutation an Op records.
+type OpKind string
+
+const (
+	OpCreate     OpKind = "create"
+	OpRemove     OpKind = "remove"
+	OpUpdate     OpKind = "update"
+	OpRename     OpKind = "rename"
+	OpConnect    OpKind = "connect"
+	OpDisconnect OpKind = "disconnect"
+)
+
+// Op is a single logged mutation. Name is the target node (the parent for edge ops),
+// Text carries create/update payloads, Other is the child of an edge or the new name of a rename
+// Label is the label of a connect and Expires is the unix-nano deadline of a create with a TTL.
+type Op[T any] struct {
+	Kind    OpKind `json:"kind"`
+	Name    string `json:"name"`
+	Text    T      `json:"text,omitempty"`
+	Other   string `json:"other,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+// WAL receives every mutation before State applies it.
+type WAL[T any] interface {
+	Append(op Op[T]) error
+}
+
+// jsonWAL writes ops as newline-delimited JSON, the format ReplayWAL reads.
+type jsonWAL[T any] struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewJSONWAL returns a WAL that appends to w.
+func NewJSONWAL[T any](w io.Writer) WAL[T] {
+	return &jsonWAL[T]{enc: json.NewEncoder(w)}
+}
+
+func (wal *jsonWAL[T]) Append(op Op[T]) error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+	return wal.enc.Encode(op)
+}
+
+// lockWAL orders logged mutations: every mutator takes it first, before any node lock, and
+// holds it until its change is applied, so ops reach the log in the order they take effect.
+// Without a WAL there is nothing to order and it is a no-op.
+func (state *State[T]) lockWAL() (unlock func()) {
+	if state.wal == nil {
+		return func() {}
+	}
+	state.walLock.Lock()
+	return state.walLock.Unlock
+}
+
+// log appends op to the state's WAL, if it has one. The caller must hold lockWAL.
+func (state *State[T]) log(op Op[T]) error {
+	if state.wal == nil {
+		return nil
+	}
+	return state.wal.Append(op)
+}
+
+// apply performs a logged op against the state.
+func (state *State[T]) apply(op Op[T]) error {
+	switch op.Kind {
+	case OpCreate:
+		if op.Expires != 0 {
+			return state.createExpiring(op.Name, op.Text, time.Unix(0, op.Expires))
+		}
+		return state.create(op.Name, op.Text)
+	case OpRemove:
+		_, err := state.remove(op.Name)
+		return err
+	case OpUpdate:
+		return state.update(op.Name, op.Text)
+	case OpRename:
+		return state.rename(op.Name, op.Other)
+	case OpConnect:
+		return state.connect(op.Name, op.Other, op.Label)
+	case OpDisconnect:
+		return state.disconnect(op.Name, op.Other)
+	}
+	return fmt.Errorf("unknown op kind %q", op.Kind)
+}
+
+// ReplayWAL reconstructs a State from a log written by NewJSONWAL.
+// Ops are logged before they are applied, so an op that failed originally is in the log too.
+// lockWAL keeps the log in the order ops were applied, so such an op meets the same graph on
+// replay, fails the same way and is skipped. Only malformed entries abort the replay.
+func ReplayWAL[T any](r io.Reader) (*State[T], error) {
+	state := &State[T]{}
+	dec := json.NewDecoder(r)
+	for entry := 1; ; entry++ {
+		var op Op[T]
+		if err := dec.Decode(&op); err != nil {
+			if errors.Is(err, io.EOF) {
+				return state, nil
+			}
+			return nil, fmt.Errorf("wal entry %d: %w", entry, err)
+		}
+		switch op.Kind {
+		case OpCreate, OpRemove, OpUpdate, OpRename, OpConnect, OpDisconnect:
+			state.apply(op)
+		default:
+			return nil, fmt.Errorf("wal entry %d: unknown op kind %q", entry, op.Kind)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayWAL(t *testing.T) {
+	var buf strings.Builder
+	st := &State[string]{wal: NewJSONWAL[string](&buf)}
+	st.create("A", "a")
+	st.create("B", "b")
+	st.create("C", "c")
+	st.create("A", "dup")
+	st.connect("A", "B", "")
+	st.connect("A", "C", "")
+	st.disconnect("A", "C")
+	st.update("B", "bb")
+	st.rename("C", "D")
+	st.remove("D")
+	replayed, err := ReplayWAL[string](strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := st.MarshalJSON()
+	if got, _ := replayed.MarshalJSON(); string(got) != string(want) {
+		t.Fatalf("replayed %s, original %s", got, want)
+	}
+	if _, err := ReplayWAL[string](strings.NewReader(`{"kind":"zap"}`)); err == nil {
+		t.Fatal("unknown op kind replayed without error")
+	}
+}
+
+// slowWAL sleeps for a random moment after every append, widening the gap between an op
+// being logged and being applied.
+type slowWAL[T any] struct {
+	WAL[T]
+}
+
+func (wal slowWAL[T]) Append(op Op[T]) error {
+	err := wal.WAL.Append(op)
+	time.Sleep(time.Duration(rand.IntN(50)) * time.Microsecond)
+	return err
+}
+
+func TestReplayWALMatchesConcurrentMutations(t *testing.T) {
+	var buf strings.Builder
+	st := &State[string]{wal: slowWAL[string]{NewJSONWAL[string](&buf)}}
+	st.create("P", "p")
+	st.create("A", "a")
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				switch i % 3 {
+				case 0:
+					st.update("A", strconv.Itoa(w))
+				case 1:
+					st.connect("P", "A", strconv.Itoa(w))
+				case 2:
+					st.disconnect("P", "A")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	replayed, err := ReplayWAL[string](strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := st.MarshalJSON()
+	got, _ := replayed.MarshalJSON()
+	if string(got) != string(want) {
+		t.Fatalf("replayed %s, original %s", got, want)
+	}
+}
+
+func TestReplayWALSkipsEdgesRefusedByChildLimit(t *testing.T) {
+	var buf strings.Builder
+	st := NewState[string](0, 1)
+	st.wal = NewJSONWAL[string](&buf)
+	for _, n := range []string{"A", "B", "C", "D"} {
+		st.create(n, n)
+	}
+	st.connect("A", "B", "")
+	if err := st.connect("A", "C", ""); !errors.Is(err, ErrChildLimit) {
+		t.Fatalf("connect over the limit = %v", err)
+	}
+	if _, err := st.connectOnce("A", "C", ""); !errors.Is(err, ErrChildLimit) {
+		t.Fatalf("connectOnce over the limit = %v", err)
+	}
+	if err := st.connectMany("A", []string{"B", "C", "D"}, "x"); !errors.Is(err, ErrChildLimit) {
+		t.Fatalf("connectMany over the limit = %v", err)
+	}
+	replayed, err := ReplayWAL[string](strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := st.MarshalJSON()
+	got, _ := replayed.MarshalJSON()
+	if string(got) != string(want) {
+		t.Fatalf("replayed %s, original %s", got, want)
+	}
+}
+
+func TestReplayWALSkipsCreatesRefusedByNodeLimit(t *testing.T) {
+	var buf strings.Builder
+	st := NewStateWithLimit[string](1)
+	st.wal = NewJSONWAL[string](&buf)
+	st.create("A", "a")
+	if err := st.create("B", "b"); !errors.Is(err, ErrNodeLimit) {
+		t.Fatalf("create over the limit = %v", err)
+	}
+	if err := st.createWithTTL("C", "c", time.Hour); !errors.Is(err, ErrNodeLimit) {
+		t.Fatalf("createWithTTL over the limit = %v", err)
+	}
+	replayed, err := ReplayWAL[string](strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := replayed.Count(); n != 1 {
+		t.Fatalf("replayed %d nodes, want 1", n)
+	}
+}